@@ -32,7 +32,16 @@ var Fss = []Fs{&MemMapFs{}, &OsFs{}}
 
 var testRegistry map[Fs][]string = make(map[Fs][]string)
 
+// tempDir makes sure the temporary directory exists on fs, as it does on
+// the OsFs.
+func tempDir(fs Fs) {
+	if err := fs.MkdirAll(os.TempDir(), 0777); err != nil {
+		panic(fmt.Sprint("unable to create the temp dir", err))
+	}
+}
+
 func testDir(fs Fs) string {
+	tempDir(fs)
 	name, err := TempDir(fs, "", "afero")
 	if err != nil {
 		panic(fmt.Sprint("unable to work with test dir", err))
@@ -43,6 +52,7 @@ func testDir(fs Fs) string {
 }
 
 func tmpFile(fs Fs) File {
+	tempDir(fs)
 	x, err := TempFile(fs, "", "afero")
 
 	if err != nil {
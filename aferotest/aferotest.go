@@ -0,0 +1,740 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aferotest provides a conformance test suite for afero.Fs
+// implementations. It checks that a filesystem behaves like the operating
+// system one does through OsFs, so that code tested against it can be trusted
+// on the real disk.
+//
+// A typical use in a _test.go file of a custom backend:
+//
+//	func TestConformance(t *testing.T) {
+//		aferotest.Suite{
+//			NewFs: func(t *testing.T) afero.Fs { return mybackend.New() },
+//		}.Run(t)
+//	}
+package aferotest
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Suite is the conformance test suite for an afero.Fs implementation.
+type Suite struct {
+	// NewFs returns the filesystem for a single test. It must be empty
+	// and treat "/" as an existing, writable directory; wrap a filesystem
+	// that is not, like OsFs, in a BasePathFs over a temporary directory.
+	NewFs func(t *testing.T) afero.Fs
+
+	// ReadOnly, if set, turns a filesystem returned by NewFs and populated
+	// by a test into the read-only filesystem under test. Only the tests
+	// which do not modify the filesystem are run, and every modification
+	// is expected to fail.
+	ReadOnly func(fs afero.Fs) afero.Fs
+
+	// Skip lists the names of tests that are known to fail for the
+	// filesystem under test, for example "OpenFileExcl".
+	Skip []string
+}
+
+// test is a single conformance test. setup populates the filesystem and
+// always has write access to it, run checks the filesystem under test.
+type test struct {
+	name   string
+	writes bool
+	setup  func(t *testing.T, fs afero.Fs)
+	run    func(t *testing.T, fs afero.Fs)
+}
+
+// Run runs every test of the suite as a subtest of t.
+func (s Suite) Run(t *testing.T) {
+	skip := make(map[string]bool)
+	for _, name := range s.Skip {
+		skip[name] = true
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		if s.ReadOnly != nil && tt.writes {
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			if skip[tt.name] {
+				t.Skip("known to fail for this filesystem")
+			}
+			fs := s.NewFs(t)
+			if tt.setup != nil {
+				tt.setup(t, fs)
+			}
+			if s.ReadOnly != nil {
+				fs = s.ReadOnly(fs)
+			}
+			tt.run(t, fs)
+		})
+	}
+
+	if s.ReadOnly != nil {
+		t.Run("ReadOnly", func(t *testing.T) {
+			if skip["ReadOnly"] {
+				t.Skip("known to fail for this filesystem")
+			}
+			fs := s.NewFs(t)
+			setupTree(t, fs)
+			testReadOnly(t, s.ReadOnly(fs))
+		})
+	}
+}
+
+const content = "Lorem ipsum dolor sit amet"
+
+// setupTree creates the tree most tests work on:
+//
+//	/dir/a.txt, /dir/b.txt, /dir/c.txt (content)
+//	/dir/sub/
+//	/file.txt (content)
+//	/empty/
+func setupTree(t *testing.T, fs afero.Fs) {
+	t.Helper()
+	mkdirAll(t, fs, "/dir/sub")
+	mkdirAll(t, fs, "/empty")
+	for _, name := range []string{"/dir/a.txt", "/dir/b.txt", "/dir/c.txt", "/file.txt"} {
+		writeFile(t, fs, name, content)
+	}
+}
+
+func mkdirAll(t *testing.T, fs afero.Fs, name string) {
+	t.Helper()
+	if err := fs.MkdirAll(name, 0755); err != nil {
+		t.Fatalf("MkdirAll(%q): %v", name, err)
+	}
+}
+
+func writeFile(t *testing.T, fs afero.Fs, name, data string) {
+	t.Helper()
+	if err := afero.WriteFile(fs, name, []byte(data), 0644); err != nil {
+		t.Fatalf("WriteFile(%q): %v", name, err)
+	}
+}
+
+func checkContent(t *testing.T, fs afero.Fs, name, want string) {
+	t.Helper()
+	data, err := afero.ReadFile(fs, name)
+	if err != nil {
+		t.Fatalf("ReadFile(%q): %v", name, err)
+	}
+	if string(data) != want {
+		t.Errorf("%s contains %q, want %q", name, data, want)
+	}
+}
+
+func checkNotExist(t *testing.T, fs afero.Fs, name string) {
+	t.Helper()
+	if _, err := fs.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("Stat(%q) returned %v, want a not exist error", name, err)
+	}
+}
+
+func checkPathError(t *testing.T, op string, err error) {
+	t.Helper()
+	if _, ok := err.(*os.PathError); !ok {
+		t.Errorf("%s returned %T (%v), want *os.PathError", op, err, err)
+	}
+}
+
+func open(t *testing.T, fs afero.Fs, name string, flag int) afero.File {
+	t.Helper()
+	f, err := fs.OpenFile(name, flag, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %v", name, err)
+	}
+	return f
+}
+
+func readDirNames(t *testing.T, fs afero.Fs, name string) []string {
+	t.Helper()
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("Open(%q): %v", name, err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames(%q): %v", name, err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkNames(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+var tests = []test{
+	{name: "Create", writes: true, run: func(t *testing.T, fs afero.Fs) {
+		f, err := fs.Create("/new.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		checkContent(t, fs, "/new.txt", content)
+
+		f, err = fs.Create("/new.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		checkContent(t, fs, "/new.txt", "")
+	}},
+
+	{name: "CreateMissingParent", writes: true, run: func(t *testing.T, fs afero.Fs) {
+		_, err := fs.Create("/missing/new.txt")
+		if !os.IsNotExist(err) {
+			t.Errorf("Create in a missing directory returned %v, want a not exist error", err)
+		}
+	}},
+
+	{name: "CreateUnderFile", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		if _, err := fs.Create("/file.txt/new.txt"); err == nil {
+			t.Error("Create below a regular file succeeded")
+		}
+	}},
+
+	{name: "OpenFileMissing", run: func(t *testing.T, fs afero.Fs) {
+		_, err := fs.OpenFile("/missing.txt", os.O_RDONLY, 0)
+		if !os.IsNotExist(err) {
+			t.Errorf("OpenFile of a missing file returned %v, want a not exist error", err)
+		}
+		checkPathError(t, "OpenFile", err)
+		_, err = fs.Open("/missing.txt")
+		if !os.IsNotExist(err) {
+			t.Errorf("Open of a missing file returned %v, want a not exist error", err)
+		}
+	}},
+
+	{name: "OpenFileCreate", writes: true, run: func(t *testing.T, fs afero.Fs) {
+		f, err := fs.OpenFile("/new.txt", os.O_WRONLY|os.O_CREATE, 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		fi, err := fs.Stat("/new.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.Mode().IsRegular() || fi.Mode().Perm() != 0640 {
+			t.Errorf("mode of created file is %v, want %v", fi.Mode(), os.FileMode(0640))
+		}
+	}},
+
+	{name: "OpenFileExcl", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		_, err := fs.OpenFile("/file.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			t.Errorf("O_EXCL on an existing file returned %v, want an exist error", err)
+		}
+		f, err := fs.OpenFile("/new.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			t.Fatalf("O_EXCL on a new file: %v", err)
+		}
+		f.Close()
+	}},
+
+	{name: "OpenFileTrunc", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDWR|os.O_TRUNC)
+		f.Close()
+		checkContent(t, fs, "/file.txt", "")
+	}},
+
+	{name: "OpenFileAppend", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDWR|os.O_APPEND)
+		if _, err := f.WriteString("|one"); err != nil {
+			t.Fatal(err)
+		}
+		// every write appends, wherever the offset was moved to
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString("|two"); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		checkContent(t, fs, "/file.txt", content+"|one|two")
+	}},
+
+	{name: "OpenFileReadOnlyHandle", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDONLY)
+		defer f.Close()
+		if _, err := f.Write([]byte("x")); err == nil {
+			t.Error("Write to a file opened O_RDONLY succeeded")
+		}
+		if err := f.Truncate(0); err == nil {
+			t.Error("Truncate of a file opened O_RDONLY succeeded")
+		}
+	}},
+
+	{name: "OpenFileWriteOnlyHandle", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_WRONLY)
+		defer f.Close()
+		if _, err := f.Read(make([]byte, 4)); err == nil {
+			t.Error("Read from a file opened O_WRONLY succeeded")
+		}
+	}},
+
+	{name: "OpenFileDirForWriting", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f, err := fs.OpenFile("/dir", os.O_WRONLY, 0)
+		if err == nil {
+			f.Close()
+			t.Error("opening a directory for writing succeeded")
+		}
+	}},
+
+	{name: "Read", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDONLY)
+		defer f.Close()
+		if n, err := f.Read(nil); n != 0 || err != nil {
+			t.Errorf("Read(nil) = %d, %v, want 0, nil", n, err)
+		}
+		buf := make([]byte, len(content)+10)
+		n, err := io.ReadFull(f, buf)
+		if err != io.ErrUnexpectedEOF || string(buf[:n]) != content {
+			t.Errorf("ReadFull = %q, %v", buf[:n], err)
+		}
+		if n, err := f.Read(buf); n != 0 || err != io.EOF {
+			t.Errorf("Read at end of file = %d, %v, want 0, io.EOF", n, err)
+		}
+	}},
+
+	{name: "Seek", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDONLY)
+		defer f.Close()
+		seek := func(offset int64, whence int, want int64) {
+			t.Helper()
+			pos, err := f.Seek(offset, whence)
+			if err != nil || pos != want {
+				t.Errorf("Seek(%d, %d) = %d, %v, want %d", offset, whence, pos, err, want)
+			}
+		}
+		seek(6, io.SeekStart, 6)
+		seek(2, io.SeekCurrent, 8)
+		seek(-4, io.SeekEnd, int64(len(content))-4)
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(f, buf); err != nil || string(buf) != content[len(content)-4:] {
+			t.Errorf("Read after Seek = %q, %v", buf, err)
+		}
+		seek(100, io.SeekStart, 100)
+		if n, err := f.Read(buf); n != 0 || err != io.EOF {
+			t.Errorf("Read past end of file = %d, %v, want 0, io.EOF", n, err)
+		}
+		if _, err := f.Seek(-1, io.SeekStart); err == nil {
+			t.Error("Seek to a negative offset succeeded")
+		}
+	}},
+
+	{name: "ReadAt", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDONLY)
+		defer f.Close()
+		buf := make([]byte, 5)
+		if n, err := f.ReadAt(buf, 6); n != 5 || err != nil || string(buf) != content[6:11] {
+			t.Errorf("ReadAt(6) = %d, %q, %v", n, buf, err)
+		}
+		// ReadAt does not use or move the offset
+		if n, err := f.Read(buf); n != 5 || err != nil || string(buf) != content[:5] {
+			t.Errorf("Read after ReadAt = %d, %q, %v, want %q", n, buf, err, content[:5])
+		}
+		off := int64(len(content)) - 2
+		if n, err := f.ReadAt(buf, off); n != 2 || err != io.EOF {
+			t.Errorf("ReadAt across end of file = %d, %v, want 2, io.EOF", n, err)
+		}
+	}},
+
+	{name: "WriteAt", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDWR)
+		if _, err := f.WriteAt([]byte("IPSUM"), 6); err != nil {
+			t.Fatal(err)
+		}
+		// WriteAt does not use or move the offset
+		if _, err := f.Write([]byte("L")); err != nil {
+			t.Fatal(err)
+		}
+		off := int64(len(content)) + 2
+		if _, err := f.WriteAt([]byte("!"), off); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		checkContent(t, fs, "/file.txt", "L"+content[1:6]+"IPSUM"+content[11:]+"\x00\x00!")
+	}},
+
+	{name: "WriteAfterSeek", writes: true, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/new.txt", os.O_RDWR|os.O_CREATE)
+		if _, err := f.Seek(3, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("abc")
+		if pos, _ := f.Seek(0, io.SeekCurrent); pos != 6 {
+			t.Errorf("offset after Write is %d, want 6", pos)
+		}
+		f.Seek(1, io.SeekStart)
+		f.WriteString("x")
+		if pos, _ := f.Seek(0, io.SeekCurrent); pos != 2 {
+			t.Errorf("offset after Write is %d, want 2", pos)
+		}
+		f.Close()
+		checkContent(t, fs, "/new.txt", "\x00x\x00abc")
+	}},
+
+	{name: "Truncate", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDWR)
+		if err := f.Truncate(5); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Truncate(7); err != nil {
+			t.Fatal(err)
+		}
+		if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+			t.Errorf("offset after Truncate is %d, want 0", pos)
+		}
+		if fi, err := f.Stat(); err != nil || fi.Size() != 7 {
+			t.Errorf("Stat after Truncate = %v, %v", fi, err)
+		}
+		f.Close()
+		checkContent(t, fs, "/file.txt", content[:5]+"\x00\x00")
+		if err := f.Truncate(0); err == nil {
+			t.Error("Truncate of a closed file succeeded")
+		}
+	}},
+
+	{name: "ReaddirAll", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/dir", os.O_RDONLY)
+		defer f.Close()
+		fis, err := f.Readdir(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+			if fi.IsDir() != (fi.Name() == "sub") {
+				t.Errorf("IsDir of %s is %v", fi.Name(), fi.IsDir())
+			}
+		}
+		checkNames(t, "Readdir(-1)", names, "a.txt", "b.txt", "c.txt", "sub")
+		if fis, err := f.Readdir(-1); len(fis) != 0 || err != nil {
+			t.Errorf("second Readdir(-1) = %v, %v, want nothing", fis, err)
+		}
+	}},
+
+	{name: "ReaddirPaging", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/dir", os.O_RDONLY)
+		defer f.Close()
+		var names []string
+		for i := 0; i < 2; i++ {
+			fis, err := f.Readdir(2)
+			if len(fis) != 2 || err != nil {
+				t.Fatalf("Readdir(2) #%d = %d entries, %v", i, len(fis), err)
+			}
+			for _, fi := range fis {
+				names = append(names, fi.Name())
+			}
+		}
+		checkNames(t, "paged Readdir", names, "a.txt", "b.txt", "c.txt", "sub")
+		if fis, err := f.Readdir(2); len(fis) != 0 || err != io.EOF {
+			t.Errorf("Readdir(2) at the end = %d entries, %v, want io.EOF", len(fis), err)
+		}
+	}},
+
+	{name: "Readdirnames", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/dir", os.O_RDONLY)
+		defer f.Close()
+		first, err := f.Readdirnames(3)
+		if len(first) != 3 || err != nil {
+			t.Fatalf("Readdirnames(3) = %v, %v", first, err)
+		}
+		rest, err := f.Readdirnames(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkNames(t, "Readdirnames", append(first, rest...), "a.txt", "b.txt", "c.txt", "sub")
+		checkNames(t, "Readdirnames of empty dir", readDirNames(t, fs, "/empty"))
+	}},
+
+	{name: "ReaddirFile", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDONLY)
+		defer f.Close()
+		if _, err := f.Readdir(-1); err == nil {
+			t.Error("Readdir of a regular file succeeded")
+		}
+	}},
+
+	{name: "Stat", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		fi, err := fs.Stat("/dir/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Name() != "a.txt" || fi.Size() != int64(len(content)) || fi.IsDir() || !fi.Mode().IsRegular() {
+			t.Errorf("Stat of a file = %s %d %v", fi.Name(), fi.Size(), fi.Mode())
+		}
+		fi, err = fs.Stat("/dir/sub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Name() != "sub" || !fi.IsDir() || !fi.Mode().IsDir() {
+			t.Errorf("Stat of a directory = %s %v", fi.Name(), fi.Mode())
+		}
+		_, err = fs.Stat("/dir/missing")
+		if !os.IsNotExist(err) {
+			t.Errorf("Stat of a missing file returned %v", err)
+		}
+		checkPathError(t, "Stat", err)
+
+		f := open(t, fs, "/dir/b.txt", os.O_RDONLY)
+		defer f.Close()
+		fi, err = f.Stat()
+		if err != nil || fi.Name() != "b.txt" || fi.Size() != int64(len(content)) {
+			t.Errorf("File.Stat = %v, %v", fi, err)
+		}
+	}},
+
+	{name: "Mkdir", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		if err := fs.Mkdir("/new", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := fs.Stat("/new"); err != nil || !fi.IsDir() {
+			t.Errorf("Stat of new directory = %v, %v", fi, err)
+		}
+		err := fs.Mkdir("/new", 0755)
+		if !os.IsExist(err) {
+			t.Errorf("Mkdir of an existing directory returned %v, want an exist error", err)
+		}
+		checkPathError(t, "Mkdir", err)
+		if err := fs.Mkdir("/file.txt", 0755); !os.IsExist(err) {
+			t.Errorf("Mkdir of an existing file returned %v, want an exist error", err)
+		}
+	}},
+
+	{name: "MkdirMissingParent", writes: true, run: func(t *testing.T, fs afero.Fs) {
+		if err := fs.Mkdir("/missing/new", 0755); !os.IsNotExist(err) {
+			t.Errorf("Mkdir in a missing directory returned %v, want a not exist error", err)
+		}
+	}},
+
+	{name: "MkdirAll", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		if err := fs.MkdirAll("/a/b/c", 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"/a", "/a/b", "/a/b/c"} {
+			if fi, err := fs.Stat(name); err != nil || !fi.IsDir() {
+				t.Errorf("Stat(%q) = %v, %v", name, fi, err)
+			}
+		}
+		if err := fs.MkdirAll("/a/b", 0755); err != nil {
+			t.Errorf("MkdirAll of an existing directory returned %v", err)
+		}
+		if err := fs.MkdirAll("/file.txt", 0755); err == nil {
+			t.Error("MkdirAll over an existing file succeeded")
+		}
+	}},
+
+	{name: "Remove", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		if err := fs.Remove("/file.txt"); err != nil {
+			t.Fatal(err)
+		}
+		checkNotExist(t, fs, "/file.txt")
+		err := fs.Remove("/file.txt")
+		if !os.IsNotExist(err) {
+			t.Errorf("Remove of a missing file returned %v, want a not exist error", err)
+		}
+		checkPathError(t, "Remove", err)
+		if err := fs.Remove("/empty"); err != nil {
+			t.Errorf("Remove of an empty directory returned %v", err)
+		}
+		checkNotExist(t, fs, "/empty")
+	}},
+
+	{name: "RemoveNonEmptyDir", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		if err := fs.Remove("/dir"); err == nil {
+			t.Error("Remove of a non-empty directory succeeded")
+		}
+		checkContent(t, fs, "/dir/a.txt", content)
+	}},
+
+	{name: "RemoveAll", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		writeFile(t, fs, "/dirfile.txt", content)
+		if err := fs.RemoveAll("/dir"); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"/dir", "/dir/a.txt", "/dir/sub"} {
+			checkNotExist(t, fs, name)
+		}
+		// siblings sharing the name as prefix stay
+		checkContent(t, fs, "/dirfile.txt", content)
+		checkNames(t, "root after RemoveAll", readDirNames(t, fs, "/"), "dirfile.txt", "empty", "file.txt")
+		if err := fs.RemoveAll("/missing"); err != nil {
+			t.Errorf("RemoveAll of a missing path returned %v", err)
+		}
+	}},
+
+	{name: "Rename", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		if err := fs.Rename("/file.txt", "/dir/renamed.txt"); err != nil {
+			t.Fatal(err)
+		}
+		checkNotExist(t, fs, "/file.txt")
+		checkContent(t, fs, "/dir/renamed.txt", content)
+		checkNames(t, "directory after Rename", readDirNames(t, fs, "/dir"), "a.txt", "b.txt", "c.txt", "renamed.txt", "sub")
+		if err := fs.Rename("/missing", "/other"); !os.IsNotExist(err) {
+			t.Errorf("Rename of a missing file returned %v, want a not exist error", err)
+		}
+	}},
+
+	{name: "RenameOverExisting", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		writeFile(t, fs, "/dir/a.txt", "replacement")
+		if err := fs.Rename("/dir/a.txt", "/file.txt"); err != nil {
+			t.Fatal(err)
+		}
+		checkNotExist(t, fs, "/dir/a.txt")
+		checkContent(t, fs, "/file.txt", "replacement")
+		checkNames(t, "root after Rename", readDirNames(t, fs, "/"), "dir", "empty", "file.txt")
+	}},
+
+	{name: "RenameDir", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		if err := fs.Rename("/dir", "/moved"); err != nil {
+			t.Fatal(err)
+		}
+		checkNotExist(t, fs, "/dir/a.txt")
+		checkContent(t, fs, "/moved/a.txt", content)
+		checkNames(t, "renamed directory", readDirNames(t, fs, "/moved"), "a.txt", "b.txt", "c.txt", "sub")
+		writeFile(t, fs, "/moved/sub/new.txt", content)
+		checkContent(t, fs, "/moved/sub/new.txt", content)
+	}},
+
+	{name: "Chmod", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		if err := fs.Chmod("/file.txt", 0600); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := fs.Stat("/file.txt"); err != nil || fi.Mode() != 0600 {
+			t.Errorf("mode after Chmod = %v, %v", fi.Mode(), err)
+		}
+		if err := fs.Chmod("/dir", 0700); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := fs.Stat("/dir"); err != nil || fi.Mode() != os.ModeDir|0700 {
+			t.Errorf("mode after Chmod = %v, %v", fi.Mode(), err)
+		}
+		if err := fs.Chmod("/missing", 0600); !os.IsNotExist(err) {
+			t.Errorf("Chmod of a missing file returned %v, want a not exist error", err)
+		}
+	}},
+
+	{name: "Chtimes", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		mtime := time.Date(2015, 10, 21, 16, 29, 0, 0, time.UTC)
+		if err := fs.Chtimes("/file.txt", mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := fs.Stat("/file.txt"); err != nil || !fi.ModTime().Equal(mtime) {
+			t.Errorf("ModTime after Chtimes = %v, %v, want %v", fi.ModTime(), err, mtime)
+		}
+		if err := fs.Chtimes("/missing", mtime, mtime); !os.IsNotExist(err) {
+			t.Errorf("Chtimes of a missing file returned %v, want a not exist error", err)
+		}
+	}},
+
+	{name: "Closed", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDONLY)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Read(make([]byte, 4)); err == nil {
+			t.Error("Read from a closed file succeeded")
+		}
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			t.Error("Seek on a closed file succeeded")
+		}
+	}},
+
+	{name: "ClosedWrite", writes: true, setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		f := open(t, fs, "/file.txt", os.O_RDWR)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("x")); err == nil {
+			t.Error("Write to a closed file succeeded")
+		}
+		checkContent(t, fs, "/file.txt", content)
+	}},
+
+	{name: "Walk", setup: setupTree, run: func(t *testing.T, fs afero.Fs) {
+		var names []string
+		err := afero.Walk(fs, "/", func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			names = append(names, path.Clean("/"+strings.Replace(name, "\\", "/", -1)))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"/", "/dir", "/dir/a.txt", "/dir/b.txt", "/dir/c.txt", "/dir/sub", "/empty", "/file.txt"}
+		if strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Errorf("Walk visited %v, want %v", names, want)
+		}
+	}},
+}
+
+// testReadOnly checks that every modification of a read-only filesystem
+// fails and leaves the tree alone.
+func testReadOnly(t *testing.T, fs afero.Fs) {
+	mtime := time.Now()
+	checks := []struct {
+		op  string
+		err error
+	}{
+		{"Chmod", fs.Chmod("/file.txt", 0600)},
+		{"Chtimes", fs.Chtimes("/file.txt", mtime, mtime)},
+		{"Mkdir", fs.Mkdir("/new", 0755)},
+		{"MkdirAll", fs.MkdirAll("/new/sub", 0755)},
+		{"Remove", fs.Remove("/file.txt")},
+		{"RemoveAll", fs.RemoveAll("/dir")},
+		{"Rename", fs.Rename("/file.txt", "/renamed.txt")},
+	}
+	if _, err := fs.Create("/new.txt"); err == nil {
+		t.Error("Create succeeded")
+	}
+	if _, err := fs.OpenFile("/file.txt", os.O_RDWR, 0); err == nil {
+		t.Error("OpenFile for writing succeeded")
+	}
+	for _, c := range checks {
+		if c.err == nil {
+			t.Errorf("%s succeeded", c.op)
+		}
+	}
+	checkContent(t, fs, "/file.txt", content)
+	checkContent(t, fs, "/dir/a.txt", content)
+	checkNotExist(t, fs, "/new")
+	if fi, err := fs.Stat("/file.txt"); err != nil || fi.Name() != "file.txt" {
+		t.Errorf("Stat = %v, %v", fi, err)
+	}
+}
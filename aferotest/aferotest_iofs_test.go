@@ -0,0 +1,29 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.16
+// +build go1.16
+
+package aferotest
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestFromIOFS(t *testing.T) {
+	Suite{NewFs: newMemMapFs, ReadOnly: func(fs afero.Fs) afero.Fs {
+		return afero.NewFromIOFS(afero.NewIOFS(fs))
+	}}.Run(t)
+}
@@ -0,0 +1,110 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aferotest
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// RegexpFs reports filtered names with a bare syscall.ENOENT, fails the
+// RemoveAll of a missing path and does not rename directories.
+var regexpFsSkip = []string{"Stat", "RemoveAll", "RenameDir"}
+
+func newOsFs(t *testing.T) afero.Fs {
+	dir, err := afero.TempDir(afero.NewOsFs(), "", "aferotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return afero.NewBasePathFs(afero.NewOsFs(), dir)
+}
+
+func newMemMapFs(t *testing.T) afero.Fs {
+	return afero.NewMemMapFs()
+}
+
+func TestOsFs(t *testing.T) {
+	Suite{NewFs: newOsFs}.Run(t)
+}
+
+func TestMemMapFs(t *testing.T) {
+	Suite{NewFs: newMemMapFs}.Run(t)
+}
+
+func TestMemMapFsEnforcePermissions(t *testing.T) {
+	Suite{NewFs: func(t *testing.T) afero.Fs {
+		fs := &afero.MemMapFs{}
+		fs.Chown("/", 1000, 1000)
+		fs.EnforcePermissions(1000, 1000, 022)
+		return fs
+	}}.Run(t)
+}
+
+func TestBasePathFs(t *testing.T) {
+	Suite{NewFs: func(t *testing.T) afero.Fs {
+		fs := afero.NewMemMapFs()
+		fs.MkdirAll("/base/path", 0777)
+		return afero.NewBasePathFs(fs, "/base/path")
+	}}.Run(t)
+}
+
+func TestCopyOnWriteFs(t *testing.T) {
+	Suite{NewFs: func(t *testing.T) afero.Fs {
+		return afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(afero.NewMemMapFs()), afero.NewMemMapFs())
+	}}.Run(t)
+}
+
+func TestCopyOnWriteFsOverOsFs(t *testing.T) {
+	Suite{NewFs: func(t *testing.T) afero.Fs {
+		return afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(newOsFs(t)), afero.NewMemMapFs())
+	}}.Run(t)
+}
+
+func TestCacheOnReadFs(t *testing.T) {
+	Suite{NewFs: func(t *testing.T) afero.Fs {
+		return afero.NewCacheOnReadFs(afero.NewMemMapFs(), afero.NewMemMapFs(), 0)
+	}}.Run(t)
+}
+
+func TestCacheOnReadFsBlocks(t *testing.T) {
+	Suite{NewFs: func(t *testing.T) afero.Fs {
+		fs := afero.NewCacheOnReadFs(afero.NewMemMapFs(), afero.NewMemMapFs(), 0).(*afero.CacheOnReadFs)
+		fs.SetBlockSize(4)
+		return fs
+	}}.Run(t)
+}
+
+func TestStatCacheFs(t *testing.T) {
+	Suite{NewFs: func(t *testing.T) afero.Fs {
+		return afero.NewStatCacheFs(afero.NewMemMapFs(), 0, 0)
+	}}.Run(t)
+}
+
+func TestRegexpFs(t *testing.T) {
+	Suite{NewFs: func(t *testing.T) afero.Fs {
+		return afero.NewRegexpFs(afero.NewMemMapFs(), regexp.MustCompile(`\.txt$`))
+	}, Skip: regexpFsSkip}.Run(t)
+}
+
+func TestReadOnlyFs(t *testing.T) {
+	Suite{NewFs: newMemMapFs, ReadOnly: afero.NewReadOnlyFs}.Run(t)
+}
+
+func TestReadOnlyFsOverOsFs(t *testing.T) {
+	Suite{NewFs: newOsFs, ReadOnly: afero.NewReadOnlyFs}.Run(t)
+}
@@ -0,0 +1,424 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnsafeArchivePath is the error wrapped in the os.PathError returned
+// when an entry of an archive being extracted would be written outside of
+// the destination directory.
+var ErrUnsafeArchivePath = errors.New("archive entry outside of the destination")
+
+// maxSymlinkTarget is the size above which the content of a zip entry
+// cannot be the target of a symbolic link.
+const maxSymlinkTarget = 4096
+
+// ExportTar writes the tree rooted at root to w as a tar archive. The names
+// of the entries are relative to root, and the archive keeps the modes and
+// modification times of the files and directories. Symbolic links are
+// archived as such when the filesystem implements Lstater and LinkReader.
+func (a Afero) ExportTar(root string, w io.Writer) error {
+	return ExportTar(a.Fs, root, w)
+}
+
+func ExportTar(fs Fs, root string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := exportTree(fs, root, func(name string, fi os.FileInfo, link string, content io.Reader) error {
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if content != nil {
+			_, err = io.Copy(tw, content)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ExportTarGz writes the tree rooted at root to w as a gzip compressed tar
+// archive, as ExportTar does.
+func (a Afero) ExportTarGz(root string, w io.Writer) error {
+	return ExportTarGz(a.Fs, root, w)
+}
+
+func ExportTarGz(fs Fs, root string, w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if err := ExportTar(fs, root, zw); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ExportZip writes the tree rooted at root to w as a zip archive, its files
+// deflated, as ExportTar does.
+func (a Afero) ExportZip(root string, w io.Writer) error {
+	return ExportZip(a.Fs, root, w)
+}
+
+func ExportZip(fs Fs, root string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	err := exportTree(fs, root, func(name string, fi os.FileInfo, link string, content io.Reader) error {
+		fh, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		fh.Name = name
+		if fi.Mode().IsRegular() {
+			fh.Method = zip.Deflate
+		}
+		ew, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		switch {
+		case content != nil:
+			_, err = io.Copy(ew, content)
+		case fi.Mode()&os.ModeSymlink != 0:
+			_, err = io.WriteString(ew, link)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// exportTree calls add for the files under root, by their slash separated
+// name relative to root, ending with a slash for the directories. content
+// is that of the regular files, and link the target of the symbolic links.
+// A root that is not a directory is added under its base name.
+func exportTree(fs Fs, root string, add func(name string, fi os.FileInfo, link string, content io.Reader) error) error {
+	return Walk(fs, root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			if fi.IsDir() {
+				return nil
+			}
+			rel = fi.Name()
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case fi.IsDir():
+			return add(rel+"/", fi, "", nil)
+		case fi.Mode()&os.ModeSymlink != 0:
+			lr, ok := fs.(LinkReader)
+			if !ok {
+				return &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+			}
+			link, err := lr.ReadlinkIfPossible(name)
+			if err != nil {
+				return err
+			}
+			return add(rel, fi, link, nil)
+		case fi.Mode().IsRegular():
+			f, err := fs.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return add(rel, fi, "", f)
+		}
+		// Devices, pipes and sockets have nothing to archive
+		return nil
+	})
+}
+
+// ExtractTar extracts the tar archive read from r, which may be gzip
+// compressed, into the directory dir, creating it if needed. Modes and
+// modification times are those of the archive, hard links are copies of
+// the files they link to, and the entries other than files, directories
+// and links are skipped.
+//
+// The archive is not trusted: an entry whose path is absolute or escapes
+// dir fails with ErrUnsafeArchivePath, as does a symbolic link whose target
+// is absolute or goes up with "..", so that no later entry can be written
+// through it outside of dir. A file larger than maxSize bytes fails with
+// ErrTooLarge; a maxSize of 0 or less sets no limit. The files extracted
+// before an error are left in place.
+func (a Afero) ExtractTar(dir string, r io.Reader, maxSize int64) error {
+	return ExtractTar(a.Fs, dir, r, maxSize)
+}
+
+func ExtractTar(fs Fs, dir string, r io.Reader, maxSize int64) error {
+	br := bufio.NewReader(r)
+	var tr *tar.Reader
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		tr = tar.NewReader(zr)
+	} else {
+		tr = tar.NewReader(br)
+	}
+
+	x := &extractor{fs: fs, dir: dir, maxSize: maxSize}
+	if err := fs.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(hdr.Name, mode, hdr.ModTime)
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.file(hdr.Name, mode, hdr.ModTime, hdr.Size, tr)
+		case tar.TypeSymlink:
+			err = x.symlink(hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = x.link(hdr.Name, hdr.Linkname, hdr.ModTime)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+// ExtractZip extracts the zip archive in the size first bytes of r into the
+// directory dir, as ExtractTar does.
+func (a Afero) ExtractZip(dir string, r io.ReaderAt, size int64, maxSize int64) error {
+	return ExtractZip(a.Fs, dir, r, size, maxSize)
+}
+
+func ExtractZip(fs Fs, dir string, r io.ReaderAt, size int64, maxSize int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	x := &extractor{fs: fs, dir: dir, maxSize: maxSize}
+	if err := fs.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.mkdir(zf.Name, mode, zf.Modified)
+		case mode.IsRegular():
+			err = x.zipFile(zf)
+		case mode&os.ModeSymlink != 0:
+			err = x.zipSymlink(zf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+// extractor writes the entries of an archive under dir in fs.
+type extractor struct {
+	fs      Fs
+	dir     string
+	maxSize int64
+
+	// The directories have their mode and time set once all the files are
+	// written in them
+	dirs []extractedDir
+}
+
+type extractedDir struct {
+	name  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// path returns the path in the filesystem of the entry name, or an error if
+// it is not under the destination directory.
+func (x *extractor) path(name string) (string, error) {
+	clean := path.Clean(strings.Replace(name, `\`, "/", -1))
+	if path.IsAbs(clean) || filepath.VolumeName(filepath.FromSlash(clean)) != "" ||
+		clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", &os.PathError{Op: "extract", Path: name, Err: ErrUnsafeArchivePath}
+	}
+	return filepath.Join(x.dir, filepath.FromSlash(clean)), nil
+}
+
+func (x *extractor) mkdir(name string, mode os.FileMode, mtime time.Time) error {
+	p, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	if err := x.fs.MkdirAll(p, 0777); err != nil {
+		return err
+	}
+	x.dirs = append(x.dirs, extractedDir{name: p, mode: mode, mtime: mtime})
+	return nil
+}
+
+func (x *extractor) file(name string, mode os.FileMode, mtime time.Time, size int64, r io.Reader) error {
+	p, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	if x.maxSize > 0 {
+		if size > x.maxSize {
+			return &os.PathError{Op: "extract", Path: name, Err: ErrTooLarge}
+		}
+		// The size announced may be a lie
+		r = io.LimitReader(r, x.maxSize+1)
+	}
+	if err := x.fs.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	f, err := x.fs.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	if x.maxSize > 0 && n > x.maxSize {
+		return &os.PathError{Op: "extract", Path: name, Err: ErrTooLarge}
+	}
+	if err := x.fs.Chmod(p, mode.Perm()); err != nil {
+		return err
+	}
+	return x.fs.Chtimes(p, mtime, mtime)
+}
+
+func (x *extractor) symlink(name, target string) error {
+	p, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	// Links in the path may make ".." go anywhere: the targets must only
+	// go down from the link
+	slashed := strings.Replace(target, `\`, "/", -1)
+	unsafe := path.IsAbs(slashed) || filepath.VolumeName(filepath.FromSlash(slashed)) != ""
+	for _, elem := range strings.Split(slashed, "/") {
+		unsafe = unsafe || elem == ".."
+	}
+	if unsafe {
+		return &os.PathError{Op: "extract", Path: name, Err: ErrUnsafeArchivePath}
+	}
+	linker, ok := x.fs.(Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: target, New: p, Err: ErrNoSymlink}
+	}
+	if err := x.fs.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	return linker.SymlinkIfPossible(filepath.FromSlash(target), p)
+}
+
+// link extracts a hard link to target, an entry extracted before, as a copy
+// of it.
+func (x *extractor) link(name, target string, mtime time.Time) error {
+	tp, err := x.path(target)
+	if err != nil {
+		return err
+	}
+	if p, err := x.path(name); err != nil || p == tp {
+		return err
+	}
+	fi, err := x.fs.Stat(tp)
+	if err != nil {
+		return err
+	}
+	f, err := x.fs.Open(tp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return x.file(name, fi.Mode(), mtime, fi.Size(), f)
+}
+
+func (x *extractor) zipFile(zf *zip.File) error {
+	if x.maxSize > 0 && zf.UncompressedSize64 > uint64(x.maxSize) {
+		return &os.PathError{Op: "extract", Path: zf.Name, Err: ErrTooLarge}
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return x.file(zf.Name, zf.Mode(), zf.Modified, int64(zf.UncompressedSize64), rc)
+}
+
+func (x *extractor) zipSymlink(zf *zip.File) error {
+	if zf.UncompressedSize64 > maxSymlinkTarget {
+		return &os.PathError{Op: "extract", Path: zf.Name, Err: ErrTooLarge}
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	target, err := ioutil.ReadAll(io.LimitReader(rc, maxSymlinkTarget))
+	if err != nil {
+		return err
+	}
+	return x.symlink(zf.Name, string(target))
+}
+
+// finish sets the modes and times of the directories, the deepest first.
+func (x *extractor) finish() error {
+	sort.SliceStable(x.dirs, func(i, j int) bool {
+		return strings.Count(x.dirs[i].name, string(filepath.Separator)) > strings.Count(x.dirs[j].name, string(filepath.Separator))
+	})
+	for _, d := range x.dirs {
+		if err := x.fs.Chmod(d.name, d.mode.Perm()); err != nil {
+			return err
+		}
+		if err := x.fs.Chtimes(d.name, d.mtime, d.mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
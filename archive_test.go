@@ -0,0 +1,185 @@
+package afero
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// archiveTree fills a MemMapFs with a tree to archive under /src.
+func archiveTree(t *testing.T) Fs {
+	fs := NewMemMapFs()
+	mtime := time.Date(2018, 3, 4, 5, 6, 8, 0, time.UTC)
+	for _, dir := range []string{"/src/bin", "/src/doc/empty"} {
+		if err := fs.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	WriteFile(fs, "/src/bin/tool", []byte("#!/bin/sh\n"), 0755)
+	WriteFile(fs, "/src/doc/README", []byte("read me"), 0644)
+	fs.(Linker).SymlinkIfPossible("doc/README", "/src/README")
+	fs.Chmod("/src/doc/empty", 0700)
+	for _, name := range []string{"/src/bin/tool", "/src/doc/README", "/src/doc/empty", "/src/doc", "/src/bin"} {
+		fs.Chtimes(name, mtime, mtime)
+	}
+	return fs
+}
+
+func checkExtracted(t *testing.T, fs Fs, dir string) {
+	t.Helper()
+	mtime := time.Date(2018, 3, 4, 5, 6, 8, 0, time.UTC)
+	for name, mode := range map[string]os.FileMode{
+		"bin":        os.ModeDir | 0755,
+		"bin/tool":   0755,
+		"doc":        os.ModeDir | 0755,
+		"doc/README": 0644,
+		"doc/empty":  os.ModeDir | 0700,
+		"README":     os.ModeSymlink,
+	} {
+		p := filepath.Join(dir, name)
+		fi, err := lstatIfPossible(fs, p)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if mode&os.ModeSymlink != 0 {
+			if fi.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("%s has a mode of %v, want a symbolic link", name, fi.Mode())
+			}
+			continue
+		}
+		if fi.Mode() != mode {
+			t.Errorf("%s has a mode of %v, want %v", name, fi.Mode(), mode)
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("%s was modified at %v, want %v", name, fi.ModTime(), mtime)
+		}
+	}
+	if data, err := ReadFile(fs, filepath.Join(dir, "README")); err != nil || string(data) != "read me" {
+		t.Errorf("README through the link contains %q, %v", data, err)
+	}
+	if data, err := ReadFile(fs, filepath.Join(dir, "bin/tool")); err != nil || string(data) != "#!/bin/sh\n" {
+		t.Errorf("bin/tool contains %q, %v", data, err)
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := archiveTree(t)
+	for _, c := range []struct {
+		name    string
+		export  func(Fs, string, *bytes.Buffer) error
+		extract func(Fs, string, *bytes.Buffer) error
+	}{
+		{"tar",
+			func(fs Fs, root string, buf *bytes.Buffer) error { return ExportTar(fs, root, buf) },
+			func(fs Fs, dir string, buf *bytes.Buffer) error { return ExtractTar(fs, dir, buf, 0) }},
+		{"tgz",
+			func(fs Fs, root string, buf *bytes.Buffer) error { return ExportTarGz(fs, root, buf) },
+			func(fs Fs, dir string, buf *bytes.Buffer) error { return ExtractTar(fs, dir, buf, 1<<20) }},
+		{"zip",
+			func(fs Fs, root string, buf *bytes.Buffer) error { return ExportZip(fs, root, buf) },
+			func(fs Fs, dir string, buf *bytes.Buffer) error {
+				return ExtractZip(fs, dir, bytes.NewReader(buf.Bytes()), int64(buf.Len()), 1<<20)
+			}},
+	} {
+		var buf bytes.Buffer
+		if err := c.export(src, "/src", &buf); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		dst := NewMemMapFs()
+		if err := c.extract(NewBasePathFs(dst, "/upload"), "/x", &buf); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		checkExtracted(t, dst, "/upload/x")
+	}
+}
+
+func TestExportCopyOnWriteFs(t *testing.T) {
+	base := archiveTree(t)
+	fs := NewCopyOnWriteFs(base, NewMemMapFs())
+	WriteFile(fs, "/src/bin/tool", []byte("patched"), 0755)
+	fs.Remove("/src/doc/README")
+
+	var buf bytes.Buffer
+	if err := ExportTar(fs, "/src/bin", &buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewMemMapFs()
+	if err := ExtractTar(dst, "/", &buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFile(dst, "/tool"); err != nil || string(data) != "patched" {
+		t.Errorf("tool contains %q, %v, want the layer content", data, err)
+	}
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	for _, hdr := range []*tar.Header{
+		{Name: "../evil", Typeflag: tar.TypeReg},
+		{Name: "a/../../evil", Typeflag: tar.TypeReg},
+		{Name: "/evil", Typeflag: tar.TypeReg},
+		{Name: `..\evil`, Typeflag: tar.TypeReg},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/"},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../x"},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a/../.."},
+		{Name: "hard", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		hdr.Mode = 0644
+		tw.WriteHeader(hdr)
+		tw.Close()
+
+		fs := NewMemMapFs()
+		err := ExtractTar(fs, "/dst/x", &buf, 0)
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != ErrUnsafeArchivePath {
+			t.Errorf("extracting %s -> %q returned %v, want ErrUnsafeArchivePath", hdr.Name, hdr.Linkname, err)
+		}
+		if names, _ := ReadDir(fs, "/dst/x"); len(names) != 0 {
+			t.Errorf("extracting %s wrote %v", hdr.Name, names)
+		}
+		if exists, _ := Exists(fs, "/dst/evil"); exists {
+			t.Errorf("extracting %s wrote outside of the destination", hdr.Name)
+		}
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.Create("../evil")
+	zw.Close()
+	err := ExtractZip(NewMemMapFs(), "/dst", bytes.NewReader(buf.Bytes()), int64(buf.Len()), 0)
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != ErrUnsafeArchivePath {
+		t.Errorf("extracting a zip entry outside of the destination returned %v", err)
+	}
+}
+
+func TestExtractTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "big", Typeflag: tar.TypeReg, Mode: 0644, Size: 100})
+	tw.Write(make([]byte, 100))
+	tw.Close()
+	tarData := buf.Bytes()
+
+	buf = bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("big")
+	w.Write(make([]byte, 100))
+	zw.Close()
+	zipData := buf.Bytes()
+
+	fs := NewMemMapFs()
+	if err := ExtractTar(fs, "/", bytes.NewReader(tarData), 99); err == nil || err.(*os.PathError).Err != ErrTooLarge {
+		t.Errorf("extracting a large tar entry returned %v, want ErrTooLarge", err)
+	}
+	if err := ExtractZip(fs, "/", bytes.NewReader(zipData), int64(len(zipData)), 99); err == nil || err.(*os.PathError).Err != ErrTooLarge {
+		t.Errorf("extracting a large zip entry returned %v, want ErrTooLarge", err)
+	}
+	if err := ExtractTar(fs, "/", bytes.NewReader(tarData), 100); err != nil {
+		t.Errorf("extracting an entry of the maximum size: %v", err)
+	}
+}
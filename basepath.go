@@ -8,7 +8,9 @@ import (
 	"time"
 )
 
-var _ Lstater = (*BasePathFs)(nil)
+var _ Symlinker = (*BasePathFs)(nil)
+var _ Chowner = (*BasePathFs)(nil)
+var _ Watcher = (*BasePathFs)(nil)
 
 // The BasePathFs restricts all operations to a given path within an Fs.
 // The given file name to the operations on this Fs will be prepended with
@@ -83,6 +85,26 @@ func (b *BasePathFs) Chmod(name string, mode os.FileMode) (err error) {
 	return b.source.Chmod(name, mode)
 }
 
+func (b *BasePathFs) Chown(name string, uid, gid int) (err error) {
+	if name, err = b.RealPath(name); err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	if chowner, ok := b.source.(Chowner); ok {
+		return chowner.Chown(name, uid, gid)
+	}
+	return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+}
+
+func (b *BasePathFs) Lchown(name string, uid, gid int) (err error) {
+	if name, err = b.RealPath(name); err != nil {
+		return &os.PathError{Op: "lchown", Path: name, Err: err}
+	}
+	if chowner, ok := b.source.(Chowner); ok {
+		return chowner.Lchown(name, uid, gid)
+	}
+	return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+}
+
 func (b *BasePathFs) Name() string {
 	return "BasePathFs"
 }
@@ -165,6 +187,29 @@ func (b *BasePathFs) Create(name string) (f File, err error) {
 	return &BasePathFile{File: sourcef, path: b.path}, nil
 }
 
+func (b *BasePathFs) Watch(name string, recursive bool) (Watch, error) {
+	path, err := b.RealPath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	watcher, ok := b.source.(Watcher)
+	if !ok {
+		return NewPollingWatcher(b, DefaultPollInterval).Watch(name, recursive)
+	}
+	w, err := watcher.Watch(path, recursive)
+	if err != nil {
+		return nil, err
+	}
+	bpath := filepath.Clean(b.path)
+	return mapWatch(w, func(e Event) Event {
+		e.Name = strings.TrimPrefix(e.Name, bpath)
+		if e.Name == "" {
+			e.Name = FilePathSeparator
+		}
+		return e
+	}), nil
+}
+
 func (b *BasePathFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
 	name, err := b.RealPath(name)
 	if err != nil {
@@ -177,4 +222,59 @@ func (b *BasePathFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
 	return fi, false, err
 }
 
+func (b *BasePathFs) SymlinkIfPossible(oldname, newname string) error {
+	target, err := b.linkTarget(oldname, newname)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	name, err := b.RealPath(newname)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if linker, ok := b.source.(Linker); ok {
+		return linker.SymlinkIfPossible(target, name)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+// linkTarget confines the target of a symbolic link created as newname to
+// the base path. Absolute targets are taken relative to the base and get the
+// base path prepended, relative targets are kept as they are as long as they
+// do not climb out of the base.
+func (b *BasePathFs) linkTarget(oldname, newname string) (string, error) {
+	if filepath.IsAbs(oldname) {
+		return b.RealPath(oldname)
+	}
+	dir := strings.TrimPrefix(filepath.Dir(filepath.Clean(newname)), FilePathSeparator)
+	resolved := filepath.Join(dir, oldname)
+	if resolved == ".." || strings.HasPrefix(resolved, ".."+FilePathSeparator) {
+		return oldname, os.ErrNotExist
+	}
+	return oldname, nil
+}
+
+func (b *BasePathFs) ReadlinkIfPossible(name string) (string, error) {
+	name, err := b.RealPath(name)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	reader, ok := b.source.(LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+	}
+	link, err := reader.ReadlinkIfPossible(name)
+	if err != nil || !filepath.IsAbs(link) {
+		return link, err
+	}
+	// Absolute targets inside the base are reported relative to the base again
+	bpath := filepath.Clean(b.path)
+	if link == bpath {
+		return FilePathSeparator, nil
+	}
+	if strings.HasPrefix(link, bpath+FilePathSeparator) {
+		return strings.TrimPrefix(link, bpath), nil
+	}
+	return link, nil
+}
+
 // vim: ts=4 sw=4 noexpandtab nolist syn=go
@@ -0,0 +1,361 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+var _ File = (*blockFile)(nil)
+
+// SetBlockSize makes u cache the files opened for reading in blocks of size
+// bytes, fetched from the base when first read. A file missing from the
+// layer is created there with its full size, and the blocks are filled in
+// on demand by Read and ReadAt. A size of 0, the default, copies the whole
+// file on open. SetBlockSize must be called before u is used.
+//
+// Opening a partially cached file for writing fetches the missing blocks
+// first. Which blocks are present is only known to u, so a file still
+// incomplete when its last handle is closed is removed from the layer
+// rather than left there to be taken as complete.
+func (u *CacheOnReadFs) SetBlockSize(size int64) {
+	u.blockSize = size
+}
+
+// blockSet tracks the blocks of a partially cached file present in the layer.
+type blockSet struct {
+	mu        sync.Mutex
+	blockSize int64
+	size      int64
+	present   []bool
+	missing   int
+	gen       int  // incremented each time the blocks are emptied
+	open      int  // the handles of the file
+	dropped   bool // the file was removed from the layer with its last handle
+
+	entry *cacheEntry // guarded by the mutex of the CacheOnReadFs
+}
+
+func newBlockSet(size, blockSize int64) *blockSet {
+	b := &blockSet{blockSize: blockSize}
+	b.reset(size)
+	return b
+}
+
+// reset marks all the blocks of a file of size bytes missing.
+func (b *blockSet) reset(size int64) {
+	n := int((size + b.blockSize - 1) / b.blockSize)
+	b.size, b.present, b.missing = size, make([]bool, n), n
+	b.gen++
+}
+
+// cached returns the number of bytes present and whether the file is
+// complete.
+func (b *blockSet) cached() (int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.missing == 0 {
+		return b.size, true
+	}
+	n := int64(len(b.present)-b.missing) * b.blockSize
+	if last := len(b.present) - 1; b.present[last] {
+		n -= int64(len(b.present))*b.blockSize - b.size
+	}
+	return n, false
+}
+
+// acquire returns the blocks of name if it is partially cached, with one
+// more handle counted on them. gone is set if the file was partially cached
+// but has been removed from the layer since.
+func (u *CacheOnReadFs) acquire(name string) (blocks *blockSet, gone bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.acquireLocked(name)
+}
+
+// acquireLocked does the work of acquire with u.mu held.
+func (u *CacheOnReadFs) acquireLocked(name string) (*blockSet, bool) {
+	e, ok := u.entries[filepath.Clean(name)]
+	if !ok || e.Value.(*cacheEntry).blocks == nil {
+		return nil, false
+	}
+	b := e.Value.(*cacheEntry).blocks
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dropped {
+		return nil, true
+	}
+	b.open++
+	return b, false
+}
+
+// release counts one handle less on blocks. With the last one, a file still
+// incomplete is removed from the layer.
+func (u *CacheOnReadFs) release(blocks *blockSet) {
+	u.mu.Lock()
+	blocks.mu.Lock()
+	blocks.open--
+	entry := blocks.entry
+	e, ok := u.entries[entry.name]
+	if blocks.open > 0 || blocks.missing == 0 || !ok || e.Value.(*cacheEntry) != entry || entry.blocks != blocks {
+		blocks.mu.Unlock()
+		u.mu.Unlock()
+		return
+	}
+	// the file stays accounted for until it is removed, acquire waits for
+	// the removal and reports the file gone
+	blocks.dropped = true
+	name := entry.name
+	u.mu.Unlock()
+	u.layer.Remove(name)
+	blocks.mu.Unlock()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if e, ok := u.entries[name]; ok && e.Value.(*cacheEntry).blocks == blocks {
+		u.forget(e)
+	}
+}
+
+// openBlocks opens name, bfi being the base file, with none of its blocks
+// cached. The file is created in the layer at its full size without copying
+// any content. If it is open already, its blocks are emptied for all its
+// handles.
+func (u *CacheOnReadFs) openBlocks(name string, bfi os.FileInfo) (File, error) {
+	if err := copyDirsToLayer(u.base, u.layer, filepath.Dir(name)); err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	u.stats.Misses++
+	blocks, _ := u.acquireLocked(name)
+	if blocks == nil {
+		blocks = newBlockSet(bfi.Size(), u.blockSize)
+		blocks.open = 1
+		u.touch(filepath.Clean(name), 0, true, blocks)
+	}
+	blocks.mu.Lock()
+	u.unlock()
+	lfh, err := u.createBlocks(name, bfi, blocks)
+	blocks.mu.Unlock()
+	if err != nil {
+		u.release(blocks)
+		return nil, err
+	}
+
+	u.mu.Lock()
+	u.touch(filepath.Clean(name), 0, false, nil)
+	u.unlock()
+	return &blockFile{fs: u, name: name, blocks: blocks, layer: lfh}, nil
+}
+
+// createBlocks creates name in the layer for openBlocks, with blocks.mu
+// held.
+func (u *CacheOnReadFs) createBlocks(name string, bfi os.FileInfo, blocks *blockSet) (File, error) {
+	blocks.reset(bfi.Size())
+	lfh, err := u.layer.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, bfi.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	if err := lfh.Truncate(bfi.Size()); err != nil {
+		lfh.Close()
+		return nil, err
+	}
+	if err := u.layer.Chmod(name, bfi.Mode()); err != nil {
+		lfh.Close()
+		return nil, err
+	}
+	return lfh, nil
+}
+
+// newBlockFile opens name, partially cached in the layer, blocks being
+// acquired for the handle.
+func (u *CacheOnReadFs) newBlockFile(name string, blocks *blockSet) (File, error) {
+	lfh, err := u.layer.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		u.release(blocks)
+		return nil, err
+	}
+	return &blockFile{fs: u, name: name, blocks: blocks, layer: lfh}, nil
+}
+
+// fillBlocks fetches the blocks of name still missing, blocks being
+// acquired for it.
+func (u *CacheOnReadFs) fillBlocks(name string, blocks *blockSet) error {
+	f, err := u.newBlockFile(name, blocks)
+	if err != nil {
+		return err
+	}
+	if err := f.(*blockFile).fetch(0, blocks.size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// blockFile is a file of a CacheOnReadFs cached block by block, reads are
+// served from the layer once the blocks they span are fetched from the base.
+type blockFile struct {
+	fs      *CacheOnReadFs
+	name    string
+	blocks  *blockSet
+	layer   File
+	base    File // opened on the first fetch, guarded by blocks.mu
+	baseGen int  // the generation of blocks base was opened for
+	off     int64
+}
+
+// fetch copies the missing blocks spanning n bytes at off to the layer.
+func (f *blockFile) fetch(off, n int64) error {
+	fetched, err := f.fetchBlocks(off, n)
+	if fetched {
+		f.fs.mu.Lock()
+		f.fs.touch(filepath.Clean(f.name), 0, false, nil)
+		f.fs.unlock()
+	}
+	return err
+}
+
+func (f *blockFile) fetchBlocks(off, n int64) (fetched bool, err error) {
+	b := f.blocks
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	end := off + n
+	if end > b.size {
+		end = b.size
+	}
+	if f.base != nil && f.baseGen != b.gen {
+		// the base file changed, its blocks were emptied by another handle
+		f.base.Close()
+		f.base = nil
+	}
+	for i := off / b.blockSize; i*b.blockSize < end; i++ {
+		if b.present[i] {
+			continue
+		}
+		if f.base == nil {
+			if f.base, err = f.fs.base.Open(f.name); err != nil {
+				return fetched, err
+			}
+			f.baseGen = b.gen
+		}
+		start := i * b.blockSize
+		buf := make([]byte, b.blockSize)
+		if start+b.blockSize > b.size {
+			buf = buf[:b.size-start]
+		}
+		m, err := f.base.ReadAt(buf, start)
+		if m < len(buf) {
+			if err == nil || err == io.EOF {
+				// the base file shrunk since it was opened
+				err = io.ErrUnexpectedEOF
+			}
+			return fetched, err
+		}
+		if _, err := f.layer.WriteAt(buf, start); err != nil {
+			return fetched, err
+		}
+		b.present[i] = true
+		b.missing--
+		fetched = true
+	}
+	return fetched, nil
+}
+
+func (f *blockFile) Close() error {
+	f.blocks.mu.Lock()
+	if f.base != nil {
+		f.base.Close()
+		f.base = nil
+	}
+	f.blocks.mu.Unlock()
+	err := f.layer.Close()
+	f.fs.release(f.blocks)
+	return err
+}
+
+func (f *blockFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *blockFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: syscall.EINVAL}
+	}
+	if err := f.fetch(off, int64(len(p))); err != nil {
+		return 0, err
+	}
+	return f.layer.ReadAt(p, off)
+}
+
+func (f *blockFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		f.blocks.mu.Lock()
+		offset += f.blocks.size
+		f.blocks.mu.Unlock()
+	case io.SeekStart:
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *blockFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: BADFD}
+}
+
+func (f *blockFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: BADFD}
+}
+
+func (f *blockFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *blockFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: BADFD}
+}
+
+func (f *blockFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+func (f *blockFile) Readdirnames(n int) ([]string, error) {
+	return nil, &os.PathError{Op: "readdirnames", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+func (f *blockFile) Name() string {
+	return f.name
+}
+
+func (f *blockFile) Stat() (os.FileInfo, error) {
+	return f.layer.Stat()
+}
+
+func (f *blockFile) Sync() error {
+	return nil
+}
@@ -1,11 +1,17 @@
 package afero
 
 import (
+	"container/list"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
+var _ File = (*cacheFile)(nil)
+
 // If the cache duration is 0, cache time will be unlimited, i.e. once
 // a file is in the layer, the base will never be read again for this file.
 //
@@ -19,10 +25,40 @@ import (
 // system first. To prevent writing to the base Fs, wrap it in a read-only
 // filter - Note: this will also make the overlay read-only, for writing files
 // in the overlay, use the overlay Fs directly, not via the union Fs.
+//
+// The size of the cache can be bounded with SetLimits, the least recently
+// used files are then removed from the layer. With SetBlockSize, files
+// opened for reading are cached block by block instead of as a whole.
 type CacheOnReadFs struct {
 	base      Fs
 	layer     Fs
 	cacheTime time.Duration
+	blockSize int64
+
+	mu       sync.Mutex
+	maxBytes int64
+	maxFiles int
+	lru      *list.List // of *cacheEntry, the most recently used first
+	entries  map[string]*list.Element
+	evicted  []string // to be removed from the layer once u.mu is released
+	stats    CacheStats
+}
+
+// CacheStats are the statistics of a CacheOnReadFs.
+type CacheStats struct {
+	Hits      int64 // files opened from the layer
+	Misses    int64 // files copied to the layer
+	Evictions int64 // files removed from the layer to stay within the limits
+	Files     int   // files in the layer accounted for by the cache
+	Bytes     int64 // the size of these files
+}
+
+// cacheEntry is a file the cache put in the layer.
+type cacheEntry struct {
+	name   string
+	size   int64
+	blocks *blockSet // nil once the file is complete
+	pins   int       // the callers of copyToLayer not done with the file
 }
 
 func NewCacheOnReadFs(base Fs, layer Fs, cacheTime time.Duration) Fs {
@@ -71,8 +107,189 @@ func (u *CacheOnReadFs) cacheStatus(name string) (state cacheState, fi os.FileIn
 	return cacheMiss, nil, err
 }
 
-func (u *CacheOnReadFs) copyToLayer(name string) error {
-	return copyToLayer(u.base, u.layer, name)
+// SetLimits bounds the cache to maxBytes in total and maxFiles files, 0
+// meaning no limit. When a limit is exceeded the least recently used files
+// are removed from the layer, but for the one just used and the partially
+// cached files still open. Files written through u are accounted for at
+// their size as it changes.
+// Only the files copied or written to the layer through u are accounted for,
+// files put in the layer by other means are never removed.
+func (u *CacheOnReadFs) SetLimits(maxBytes int64, maxFiles int) {
+	u.mu.Lock()
+	defer u.unlock()
+	u.maxBytes, u.maxFiles = maxBytes, maxFiles
+	u.evict()
+}
+
+// Stats returns the current statistics of the cache.
+func (u *CacheOnReadFs) Stats() CacheStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.stats
+}
+
+// unlock releases u.mu and removes the files evicted meanwhile from the
+// layer.
+func (u *CacheOnReadFs) unlock() {
+	evicted := u.evicted
+	u.evicted = nil
+	u.mu.Unlock()
+	for _, name := range evicted {
+		u.layer.Remove(name)
+	}
+}
+
+// copyToLayer copies name to the layer. The file is pinned in the cache
+// until the caller, done with it in the layer, calls unpin with the entry
+// returned.
+func (u *CacheOnReadFs) copyToLayer(name string) (*cacheEntry, error) {
+	if err := copyToLayer(u.base, u.layer, name); err != nil {
+		return nil, err
+	}
+	fi, err := u.layer.Stat(name)
+	u.mu.Lock()
+	defer u.unlock()
+	u.stats.Misses++
+	if err != nil || fi.IsDir() {
+		return nil, nil
+	}
+	entry := u.touch(filepath.Clean(name), fi.Size(), true, nil)
+	entry.pins++
+	return entry, nil
+}
+
+// unpin releases a file pinned by copyToLayer.
+func (u *CacheOnReadFs) unpin(entry *cacheEntry) {
+	if entry == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.unlock()
+	entry.pins--
+	u.evict()
+}
+
+// track marks name as the most recently used file and updates its size. A
+// file the cache does not account for yet is only added if add is set.
+func (u *CacheOnReadFs) track(name string, add bool) {
+	fi, err := u.layer.Stat(name)
+	if err != nil || fi.IsDir() {
+		return
+	}
+	u.mu.Lock()
+	defer u.unlock()
+	u.touch(filepath.Clean(name), fi.Size(), add, nil)
+}
+
+// touch does the work of track for a file of the given size. With blocks
+// set, the file is only partially cached and its size is the one of the
+// blocks present. It returns the entry of the file, nil if it is not
+// accounted for. It is called with u.mu held.
+func (u *CacheOnReadFs) touch(name string, size int64, add bool, blocks *blockSet) *cacheEntry {
+	if u.lru == nil {
+		u.lru = list.New()
+		u.entries = make(map[string]*list.Element)
+	}
+	e, ok := u.entries[name]
+	if !ok {
+		if !add {
+			return nil
+		}
+		e = u.lru.PushFront(&cacheEntry{name: name})
+		u.entries[name] = e
+		u.stats.Files++
+	}
+	entry := e.Value.(*cacheEntry)
+	if blocks != nil || add {
+		entry.blocks = blocks
+	}
+	if blocks != nil {
+		blocks.entry = entry
+	}
+	if entry.blocks != nil {
+		if cached, complete := entry.blocks.cached(); complete {
+			entry.blocks = nil
+		} else {
+			size = cached
+		}
+	}
+	u.stats.Bytes += size - entry.size
+	entry.size = size
+	u.lru.MoveToFront(e)
+	u.evict()
+	return entry
+}
+
+// hit counts a file opened from the layer. The file is pinned as by
+// copyToLayer.
+func (u *CacheOnReadFs) hit(name string) *cacheEntry {
+	fi, err := u.layer.Stat(name)
+	u.mu.Lock()
+	defer u.unlock()
+	u.stats.Hits++
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	entry := u.touch(filepath.Clean(name), fi.Size(), false, nil)
+	if entry != nil {
+		entry.pins++
+	}
+	return entry
+}
+
+// evict stops accounting for the least recently used files until the cache
+// is within its limits, they are removed from the layer by unlock. Pinned
+// and partially cached files are skipped, the latter are removed by their
+// last handle if still incomplete. It is called with u.mu held.
+func (u *CacheOnReadFs) evict() {
+	if u.lru == nil {
+		return
+	}
+	for e := u.lru.Back(); e != u.lru.Front() &&
+		(u.maxBytes > 0 && u.stats.Bytes > u.maxBytes || u.maxFiles > 0 && u.stats.Files > u.maxFiles); {
+		prev := e.Prev()
+		if entry := e.Value.(*cacheEntry); entry.pins == 0 && entry.blocks == nil {
+			u.forget(e)
+			u.evicted = append(u.evicted, entry.name)
+			u.stats.Evictions++
+		}
+		e = prev
+	}
+}
+
+// forget stops accounting for the file of e. It is called with u.mu held.
+func (u *CacheOnReadFs) forget(e *list.Element) *cacheEntry {
+	entry := u.lru.Remove(e).(*cacheEntry)
+	delete(u.entries, entry.name)
+	u.stats.Files--
+	u.stats.Bytes -= entry.size
+	return entry
+}
+
+// untrack stops accounting for name and, with all set, the files below it.
+// With newname set they are accounted for under their new name instead.
+func (u *CacheOnReadFs) untrack(name string, all bool, newname string) {
+	name = filepath.Clean(name)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.lru == nil {
+		return
+	}
+	for e := u.lru.Front(); e != nil; {
+		next := e.Next()
+		entry := e.Value.(*cacheEntry)
+		below := all && strings.HasPrefix(entry.name, name+FilePathSeparator)
+		if entry.name == name || below {
+			if newname == "" {
+				u.forget(e)
+			} else {
+				delete(u.entries, entry.name)
+				entry.name = filepath.Join(newname, strings.TrimPrefix(entry.name, name))
+				u.entries[entry.name] = e
+			}
+		}
+		e = next
+	}
 }
 
 func (u *CacheOnReadFs) Chtimes(name string, atime, mtime time.Time) error {
@@ -85,9 +302,11 @@ func (u *CacheOnReadFs) Chtimes(name string, atime, mtime time.Time) error {
 	case cacheHit:
 		err = u.base.Chtimes(name, atime, mtime)
 	case cacheStale, cacheMiss:
-		if err := u.copyToLayer(name); err != nil {
+		var entry *cacheEntry
+		if entry, err = u.copyToLayer(name); err != nil {
 			return err
 		}
+		defer u.unpin(entry)
 		err = u.base.Chtimes(name, atime, mtime)
 	}
 	if err != nil {
@@ -106,9 +325,11 @@ func (u *CacheOnReadFs) Chmod(name string, mode os.FileMode) error {
 	case cacheHit:
 		err = u.base.Chmod(name, mode)
 	case cacheStale, cacheMiss:
-		if err := u.copyToLayer(name); err != nil {
+		var entry *cacheEntry
+		if entry, err = u.copyToLayer(name); err != nil {
 			return err
 		}
+		defer u.unpin(entry)
 		err = u.base.Chmod(name, mode)
 	}
 	if err != nil {
@@ -117,6 +338,42 @@ func (u *CacheOnReadFs) Chmod(name string, mode os.FileMode) error {
 	return u.layer.Chmod(name, mode)
 }
 
+func (u *CacheOnReadFs) Chown(name string, uid, gid int) error {
+	return u.chown("chown", name, uid, gid, Chowner.Chown)
+}
+
+func (u *CacheOnReadFs) Lchown(name string, uid, gid int) error {
+	return u.chown("lchown", name, uid, gid, Chowner.Lchown)
+}
+
+func (u *CacheOnReadFs) chown(op, name string, uid, gid int, chown func(Chowner, string, int, int) error) error {
+	bchowner, ok1 := u.base.(Chowner)
+	lchowner, ok2 := u.layer.(Chowner)
+	if !ok1 || !ok2 {
+		return &os.PathError{Op: op, Path: name, Err: ErrNoChown}
+	}
+	st, _, err := u.cacheStatus(name)
+	if err != nil {
+		return err
+	}
+	switch st {
+	case cacheLocal:
+	case cacheHit:
+		err = chown(bchowner, name, uid, gid)
+	case cacheStale, cacheMiss:
+		var entry *cacheEntry
+		if entry, err = u.copyToLayer(name); err != nil {
+			return err
+		}
+		defer u.unpin(entry)
+		err = chown(bchowner, name, uid, gid)
+	}
+	if err != nil {
+		return err
+	}
+	return chown(lchowner, name, uid, gid)
+}
+
 func (u *CacheOnReadFs) Stat(name string) (os.FileInfo, error) {
 	st, fi, err := u.cacheStatus(name)
 	if err != nil {
@@ -140,15 +397,25 @@ func (u *CacheOnReadFs) Rename(oldname, newname string) error {
 	case cacheHit:
 		err = u.base.Rename(oldname, newname)
 	case cacheStale, cacheMiss:
-		if err := u.copyToLayer(oldname); err != nil {
+		var entry *cacheEntry
+		if entry, err = u.copyToLayer(oldname); err != nil {
 			return err
 		}
+		defer u.unpin(entry)
 		err = u.base.Rename(oldname, newname)
 	}
 	if err != nil {
 		return err
 	}
-	return u.layer.Rename(oldname, newname)
+	if err := copyDirsToLayer(u.base, u.layer, filepath.Dir(newname)); err != nil {
+		return err
+	}
+	if err := u.layer.Rename(oldname, newname); err != nil {
+		return err
+	}
+	u.untrack(newname, true, "")
+	u.untrack(oldname, true, filepath.Clean(newname))
+	return nil
 }
 
 func (u *CacheOnReadFs) Remove(name string) error {
@@ -164,6 +431,7 @@ func (u *CacheOnReadFs) Remove(name string) error {
 	if err != nil {
 		return err
 	}
+	u.untrack(name, false, "")
 	return u.layer.Remove(name)
 }
 
@@ -180,32 +448,61 @@ func (u *CacheOnReadFs) RemoveAll(name string) error {
 	if err != nil {
 		return err
 	}
+	u.untrack(name, true, "")
 	return u.layer.RemoveAll(name)
 }
 
 func (u *CacheOnReadFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	writing := flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
+	if !writing && u.blockSize > 0 {
+		return u.Open(name)
+	}
 	st, _, err := u.cacheStatus(name)
 	if err != nil {
 		return nil, err
 	}
 	switch st {
-	case cacheLocal, cacheHit:
+	case cacheLocal:
+	case cacheHit:
+		blocks, gone := u.acquire(name)
+		if gone {
+			return u.OpenFile(name, flag, perm)
+		}
+		defer u.unpin(u.hit(name))
+		// the blocks missing would be holes in the file written
+		if blocks != nil {
+			if err := u.fillBlocks(name, blocks); err != nil {
+				return nil, err
+			}
+		}
 	default:
-		if err := u.copyToLayer(name); err != nil {
-			return nil, err
+		var entry *cacheEntry
+		if entry, err = u.copyToLayer(name); err != nil {
+			// a new file is created below, there is nothing to copy
+			if flag&os.O_CREATE == 0 || !os.IsNotExist(err) {
+				return nil, err
+			}
 		}
+		defer u.unpin(entry)
 	}
-	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+	if writing {
 		bfi, err := u.base.OpenFile(name, flag, perm)
 		if err != nil {
 			return nil, err
 		}
+		if flag&os.O_CREATE != 0 {
+			if err := u.layer.MkdirAll(filepath.Dir(name), 0777); err != nil {
+				bfi.Close()
+				return nil, err
+			}
+		}
 		lfi, err := u.layer.OpenFile(name, flag, perm)
 		if err != nil {
 			bfi.Close() // oops, what if O_TRUNC was set and file opening in the layer failed...?
 			return nil, err
 		}
-		return &UnionFile{Base: bfi, Layer: lfi}, nil
+		u.track(name, true)
+		return &cacheFile{UnionFile: &UnionFile{Base: bfi, Layer: lfi}, fs: u, name: name}, nil
 	}
 	return u.layer.OpenFile(name, flag, perm)
 }
@@ -228,20 +525,38 @@ func (u *CacheOnReadFs) Open(name string) (File, error) {
 		if bfi.IsDir() {
 			return u.base.Open(name)
 		}
-		if err := u.copyToLayer(name); err != nil {
+		if u.blockSize > 0 {
+			return u.openBlocks(name, bfi)
+		}
+		entry, err := u.copyToLayer(name)
+		if err != nil {
 			return nil, err
 		}
+		defer u.unpin(entry)
 		return u.layer.Open(name)
 
 	case cacheStale:
+		if !fi.IsDir() && u.blockSize > 0 {
+			return u.openBlocks(name, fi)
+		}
 		if !fi.IsDir() {
-			if err := u.copyToLayer(name); err != nil {
+			entry, err := u.copyToLayer(name)
+			if err != nil {
 				return nil, err
 			}
+			defer u.unpin(entry)
 			return u.layer.Open(name)
 		}
 	case cacheHit:
 		if !fi.IsDir() {
+			blocks, gone := u.acquire(name)
+			if gone {
+				return u.Open(name)
+			}
+			defer u.unpin(u.hit(name))
+			if blocks != nil {
+				return u.newBlockFile(name, blocks)
+			}
 			return u.layer.Open(name)
 		}
 	}
@@ -279,6 +594,10 @@ func (u *CacheOnReadFs) Create(name string) (File, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := u.layer.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		bfh.Close()
+		return nil, err
+	}
 	lfh, err := u.layer.Create(name)
 	if err != nil {
 		// oops, see comment about OS_TRUNC above, should we remove? then we have to
@@ -286,5 +605,42 @@ func (u *CacheOnReadFs) Create(name string) (File, error) {
 		bfh.Close()
 		return nil, err
 	}
-	return &UnionFile{Base: bfh, Layer: lfh}, nil
+	u.track(name, true)
+	return &cacheFile{UnionFile: &UnionFile{Base: bfh, Layer: lfh}, fs: u, name: name}, nil
+}
+
+// cacheFile is a file of a CacheOnReadFs opened for writing, the size of the
+// layer file is accounted for again as it changes.
+type cacheFile struct {
+	*UnionFile
+	fs   *CacheOnReadFs
+	name string
+}
+
+func (f *cacheFile) Close() error {
+	err := f.UnionFile.Close()
+	f.fs.track(f.name, false)
+	return err
+}
+
+func (f *cacheFile) Write(p []byte) (int, error) {
+	n, err := f.UnionFile.Write(p)
+	f.fs.track(f.name, false)
+	return n, err
+}
+
+func (f *cacheFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.UnionFile.WriteAt(p, off)
+	f.fs.track(f.name, false)
+	return n, err
+}
+
+func (f *cacheFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *cacheFile) Truncate(size int64) error {
+	err := f.UnionFile.Truncate(size)
+	f.fs.track(f.name, false)
+	return err
 }
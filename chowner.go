@@ -0,0 +1,38 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"errors"
+)
+
+// Chowner is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It changes the numeric uid and gid of the named file, as os.Chown and
+// os.Lchown do. Filesystems wrapping a filesystem without ownership return
+// an *os.PathError wrapping ErrNoChown.
+type Chowner interface {
+	// Chown changes the numeric uid and gid of the named file. If the file
+	// is a symbolic link, it changes the uid and gid of the link's target.
+	Chown(name string, uid, gid int) error
+
+	// Lchown changes the numeric uid and gid of the named file. If the file
+	// is a symbolic link, it changes the uid and gid of the link itself.
+	Lchown(name string, uid, gid int) error
+}
+
+// ErrNoChown is the error that will be wrapped in an os.PathError if a file
+// system does not support changing ownership either directly or through its
+// delegated filesystem.
+var ErrNoChown = errors.New("chown not supported")
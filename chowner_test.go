@@ -0,0 +1,92 @@
+// Copyright ©2018 Steve Francia <spf@spf13.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero/mem"
+)
+
+func TestChown(t *testing.T) {
+	memFs := NewMemMapFs()
+	memFs.MkdirAll("/chown", 0777)
+	WriteFile(memFs, "/chown/file.txt", []byte("Hi, Afero!"), 0644)
+	memFs.(Linker).SymlinkIfPossible("file.txt", "/chown/link.txt")
+
+	overlayFs := &CopyOnWriteFs{base: &ReadOnlyFs{source: memFs}, layer: NewMemMapFs()}
+	basePathFs := &BasePathFs{source: memFs, path: "/chown"}
+
+	owner := func(fs Fs, name string, lstat bool) (int, int) {
+		var fi os.FileInfo
+		var err error
+		if lstat {
+			fi, _, err = fs.(Lstater).LstatIfPossible(name)
+		} else {
+			fi, err = fs.Stat(name)
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", fs.Name(), err)
+		}
+		st, ok := fi.Sys().(*mem.Stat)
+		if !ok {
+			t.Fatalf("%s: Sys() returned %T", fs.Name(), fi.Sys())
+		}
+		return st.Uid, st.Gid
+	}
+
+	testChown := func(fs Fs, dir string) {
+		file := dir + "/file.txt"
+		link := dir + "/link.txt"
+		chowner := fs.(Chowner)
+
+		if err := chowner.Chown(link, 1000, 100); err != nil {
+			t.Fatalf("%s: Chown failed: %s", fs.Name(), err)
+		}
+		if uid, gid := owner(fs, file, false); uid != 1000 || gid != 100 {
+			t.Errorf("%s: Chown through link set %d:%d", fs.Name(), uid, gid)
+		}
+
+		if err := chowner.Lchown(link, 2000, -1); err != nil {
+			t.Fatalf("%s: Lchown failed: %s", fs.Name(), err)
+		}
+		if uid, gid := owner(fs, link, true); uid != 2000 || gid == 100 {
+			t.Errorf("%s: Lchown set %d:%d on the link", fs.Name(), uid, gid)
+		}
+		if uid, gid := owner(fs, file, false); uid != 1000 || gid != 100 {
+			t.Errorf("%s: Lchown changed the target to %d:%d", fs.Name(), uid, gid)
+		}
+
+		if err := chowner.Chown(dir+"/missing.txt", 0, 0); !os.IsNotExist(err) {
+			t.Errorf("%s: Chown of a missing file returned %v", fs.Name(), err)
+		}
+	}
+
+	testChown(overlayFs, "/chown")
+	if uid, _ := owner(memFs, "/chown/file.txt", false); uid == 1000 {
+		t.Error("CopyOnWriteFs: Chown changed the base layer")
+	}
+	if fi, _, _ := overlayFs.layer.(Lstater).LstatIfPossible("/chown/link.txt"); fi == nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Error("CopyOnWriteFs: Lchown did not copy the link to the overlay")
+	}
+
+	testChown(memFs, "/chown")
+	testChown(basePathFs, "")
+
+	if err := (&ReadOnlyFs{source: memFs}).Chown("/chown/file.txt", 0, 0); err == nil {
+		t.Error("ReadOnlyFs: Chown should fail")
+	}
+}
@@ -3,6 +3,7 @@ package afero
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -401,3 +402,233 @@ func TestCacheOnReadFsNotInLayer(t *testing.T) {
 	}
 	fh.Close()
 }
+
+func TestCacheOnReadFsLimits(t *testing.T) {
+	base := NewMemMapFs()
+	layer := NewMemMapFs()
+	fs := NewCacheOnReadFs(base, layer, 0).(*CacheOnReadFs)
+	fs.SetLimits(0, 2)
+
+	for _, name := range []string{"/a", "/b", "/c"} {
+		WriteFile(base, name, []byte("0123456789"), 0644)
+	}
+	inLayer := func(name string) bool {
+		_, err := layer.Stat(name)
+		return err == nil
+	}
+
+	ReadFile(fs, "/a")
+	ReadFile(fs, "/b")
+	ReadFile(fs, "/a") // /b is now the least recently used
+	ReadFile(fs, "/c")
+	if !inLayer("/a") || inLayer("/b") || !inLayer("/c") {
+		t.Errorf("want /a and /c in the layer, /b evicted")
+	}
+	want := CacheStats{Hits: 1, Misses: 3, Evictions: 1, Files: 2, Bytes: 20}
+	if got := fs.Stats(); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+
+	// Lowering the limits evicts right away, but for the file just used
+	fs.SetLimits(15, 0)
+	if inLayer("/a") || !inLayer("/c") {
+		t.Errorf("want /c in the layer, /a evicted")
+	}
+	WriteFile(base, "/big", make([]byte, 20), 0644)
+	ReadFile(fs, "/big")
+	if inLayer("/c") || !inLayer("/big") {
+		t.Errorf("want /big in the layer, /c evicted")
+	}
+	if got := fs.Stats(); got.Files != 1 || got.Bytes != 20 || got.Evictions != 3 {
+		t.Errorf("got stats %+v, want 1 file of 20 bytes and 3 evictions", got)
+	}
+
+	// Files of the layer the cache did not put there are never evicted
+	WriteFile(layer, "/local", make([]byte, 100), 0644)
+	ReadFile(fs, "/local")
+	if !inLayer("/local") {
+		t.Error("/local evicted")
+	}
+
+	fs.Rename("/big", "/renamed")
+	fs.Remove("/renamed")
+	if got := fs.Stats(); got.Files != 0 || got.Bytes != 0 {
+		t.Errorf("got stats %+v, want an empty cache", got)
+	}
+}
+
+func TestCacheOnReadFsLimitsWrite(t *testing.T) {
+	base := NewMemMapFs()
+	layer := NewMemMapFs()
+	fs := NewCacheOnReadFs(base, layer, 0).(*CacheOnReadFs)
+	fs.SetLimits(50, 0)
+
+	for _, name := range []string{"/a", "/b"} {
+		f, err := fs.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	want := CacheStats{Evictions: 1, Files: 1, Bytes: 100}
+	if got := fs.Stats(); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+	if _, err := layer.Stat("/a"); !os.IsNotExist(err) {
+		t.Errorf("/a not evicted: %v", err)
+	}
+}
+
+// openHookFs calls hook before opening a file.
+type openHookFs struct {
+	Fs
+	hook func()
+}
+
+func (fs *openHookFs) Open(name string) (File, error) {
+	if hook := fs.hook; hook != nil {
+		fs.hook = nil
+		hook()
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestCacheOnReadFsLimitsPinned(t *testing.T) {
+	base := NewMemMapFs()
+	layer := &openHookFs{Fs: NewMemMapFs()}
+	fs := NewCacheOnReadFs(base, layer, 0).(*CacheOnReadFs)
+	fs.SetLimits(0, 1)
+	WriteFile(base, "/a", []byte("a"), 0644)
+	WriteFile(base, "/b", []byte("b"), 0644)
+
+	// /b is used between copying /a to the layer and opening it
+	layer.hook = func() { ReadFile(fs, "/b") }
+	if data, err := ReadFile(fs, "/a"); err != nil || string(data) != "a" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+	if got := fs.Stats(); got.Files != 1 || got.Evictions != 1 {
+		t.Errorf("got stats %+v, want 1 file and 1 eviction", got)
+	}
+}
+
+func TestCacheOnReadFsBlocks(t *testing.T) {
+	base := NewMemMapFs()
+	layer := NewMemMapFs()
+	fs := NewCacheOnReadFs(base, layer, 0).(*CacheOnReadFs)
+	fs.SetBlockSize(4)
+	WriteFile(base, "/file", []byte("0123456789"), 0644)
+
+	f, err := fs.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 2)
+	if _, err := f.ReadAt(buf, 5); err != nil || string(buf) != "56" {
+		t.Fatalf("ReadAt returned %q, %v", buf, err)
+	}
+	if fi, err := layer.Stat("/file"); err != nil || fi.Size() != 10 {
+		t.Fatalf("layer file: %v, %v, want a file of 10 bytes", fi, err)
+	}
+	if got := fs.Stats(); got.Bytes != 4 || got.Misses != 1 {
+		t.Errorf("got stats %+v, want one miss of 4 bytes", got)
+	}
+
+	// Only the second block is cached, changes to the others show up
+	WriteFile(base, "/file", []byte("abcdefghij"), 0644)
+	if _, err := f.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ReadAll(f)
+	if err != nil || string(rest) != "7ij" {
+		t.Fatalf("read %q, %v, want \"7ij\"", rest, err)
+	}
+	if got := fs.Stats(); got.Bytes != 6 {
+		t.Errorf("got %d bytes cached, want 6", got.Bytes)
+	}
+
+	// A partially cached file opened for writing is completed first
+	w, err := fs.OpenFile("/file", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if data, _ := ReadFile(layer, "/file"); string(data) != "abcd4567ij" {
+		t.Errorf("layer file is %q, want \"abcd4567ij\"", data)
+	}
+	if got := fs.Stats(); got.Bytes != 10 {
+		t.Errorf("got %d bytes cached, want 10", got.Bytes)
+	}
+}
+
+func TestCacheOnReadFsBlocksPartialClose(t *testing.T) {
+	base := NewMemMapFs()
+	layer := NewMemMapFs()
+	fs := NewCacheOnReadFs(base, layer, 0).(*CacheOnReadFs)
+	fs.SetBlockSize(4)
+	WriteFile(base, "/file", []byte("0123456789"), 0644)
+
+	f, err := fs.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 42); err == nil {
+		t.Error("Seek accepted an invalid whence")
+	}
+	if _, err := f.ReadAt(make([]byte, 2), 5); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	// Nothing but u knew which blocks were missing
+	if _, err := layer.Stat("/file"); !os.IsNotExist(err) {
+		t.Errorf("partially cached file left in the layer: %v", err)
+	}
+	if got := fs.Stats(); got.Files != 0 || got.Bytes != 0 {
+		t.Errorf("got stats %+v, want an empty cache", got)
+	}
+
+	// A complete file is kept
+	if data, err := ReadFile(fs, "/file"); err != nil || string(data) != "0123456789" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+	if data, _ := ReadFile(layer, "/file"); string(data) != "0123456789" {
+		t.Errorf("layer file is %q, want the base file", data)
+	}
+}
+
+func TestCacheOnReadFsBlocksStale(t *testing.T) {
+	base := NewMemMapFs()
+	layer := NewMemMapFs()
+	fs := NewCacheOnReadFs(base, layer, time.Nanosecond).(*CacheOnReadFs)
+	fs.SetBlockSize(4)
+	WriteFile(base, "/file", []byte("0123456789"), 0644)
+
+	f, err := fs.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 2)
+	if _, err := f.ReadAt(buf, 4); err != nil || string(buf) != "45" {
+		t.Fatalf("ReadAt returned %q, %v", buf, err)
+	}
+
+	WriteFile(base, "/file", []byte("abcdefghij"), 0644)
+	later := time.Now().Add(time.Hour)
+	base.Chtimes("/file", later, later)
+	g, err := fs.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// The blocks are fetched again for both handles
+	for _, h := range []File{f, g} {
+		if _, err := h.ReadAt(buf, 4); err != nil || string(buf) != "ef" {
+			t.Errorf("ReadAt returned %q, %v, want \"ef\"", buf, err)
+		}
+	}
+}
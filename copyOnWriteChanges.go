@@ -0,0 +1,310 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChangeKind tells how a path differs between a CopyOnWriteFs and its base.
+type ChangeKind int
+
+const (
+	// ChangeAdded is a path missing from the base layer.
+	ChangeAdded ChangeKind = iota
+	// ChangeModified is a path whose content or type changed.
+	ChangeModified
+	// ChangeDeleted is a path removed from the union.
+	ChangeDeleted
+	// ChangeMode is a path of which only the permissions changed.
+	ChangeMode
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeMode:
+		return "mode changed"
+	}
+	return "unknown"
+}
+
+// Change is a difference between a CopyOnWriteFs and its base layer.
+type Change struct {
+	Kind ChangeKind
+	Path string
+}
+
+func (c Change) String() string {
+	return c.Kind.String() + " " + c.Path
+}
+
+// modeBits are the bits of a FileMode compared by Changes.
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Changes returns the differences between the union and the base layer,
+// sorted by path. The removal of a directory is a single change, the
+// content of a new directory is added path by path. Changes in the
+// modification time only are not reported.
+func (u *CopyOnWriteFs) Changes() ([]Change, error) {
+	var changes []Change
+	if err := u.changes(FilePathSeparator, false, &changes); err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// Commit applies the Changes to target, the base layer if target is nil,
+// and returns them. With dryRun set, target is left alone. On error, the
+// changes returned are the ones applied.
+//
+// The base layer is usually a ReadOnlyFs, to commit onto it pass the Fs it
+// wraps as target. The overlay is not cleared.
+func (u *CopyOnWriteFs) Commit(target Fs, dryRun bool) ([]Change, error) {
+	if target == nil {
+		target = u.base
+	}
+	changes, err := u.Changes()
+	if err != nil || dryRun {
+		return changes, err
+	}
+	for i, c := range changes {
+		if err := u.commit(target, c); err != nil {
+			return changes[:i], err
+		}
+	}
+	return changes, nil
+}
+
+// changes collects the changes below dir, a directory of the overlay. In an
+// opaque directory the content of the base layer is gone, but for what the
+// overlay has.
+func (u *CopyOnWriteFs) changes(dir string, opaque bool, changes *[]Change) error {
+	lfis, err := ReadDir(u.layer, dir)
+	if err != nil {
+		return err
+	}
+	var entries []os.FileInfo
+	var whiteouts []string
+	inLayer := make(map[string]bool)
+	for _, fi := range lfis {
+		switch name := fi.Name(); {
+		case name == whiteoutOpaque:
+			opaque = true
+		case strings.HasPrefix(name, whiteoutPrefix):
+			whiteouts = append(whiteouts, strings.TrimPrefix(name, whiteoutPrefix))
+		default:
+			inLayer[name] = true
+			entries = append(entries, fi)
+		}
+	}
+
+	if opaque {
+		bfis, err := ReadDir(u.base, dir)
+		if err != nil && !u.isNotExist(err) {
+			return err
+		}
+		for _, fi := range bfis {
+			if !inLayer[fi.Name()] {
+				*changes = append(*changes, Change{Kind: ChangeDeleted, Path: filepath.Join(dir, fi.Name())})
+			}
+		}
+	} else {
+		for _, name := range whiteouts {
+			path := filepath.Join(dir, name)
+			if inLayer[name] {
+				continue
+			}
+			if _, err := lstatIfPossible(u.base, path); err == nil {
+				*changes = append(*changes, Change{Kind: ChangeDeleted, Path: path})
+			} else if !u.isNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	for _, lfi := range entries {
+		path := filepath.Join(dir, lfi.Name())
+		bfi, err := lstatIfPossible(u.base, path)
+		if err != nil {
+			if !u.isNotExist(err) {
+				return err
+			}
+			bfi = nil
+		}
+		kind, changed, err := u.compare(path, lfi, bfi)
+		if err != nil {
+			return err
+		}
+		if changed {
+			*changes = append(*changes, Change{Kind: kind, Path: path})
+		}
+		if lfi.IsDir() {
+			if err := u.changes(path, opaque, changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compare tells how path, lfi in the overlay and bfi in the base layer,
+// changed. bfi is nil if the base layer does not have path.
+func (u *CopyOnWriteFs) compare(path string, lfi, bfi os.FileInfo) (ChangeKind, bool, error) {
+	if bfi == nil {
+		return ChangeAdded, true, nil
+	}
+	if lfi.Mode()&os.ModeType != bfi.Mode()&os.ModeType {
+		return ChangeModified, true, nil
+	}
+
+	switch {
+	case lfi.Mode()&os.ModeSymlink != 0:
+		rlayer, ok1 := u.layer.(LinkReader)
+		rbase, ok2 := u.base.(LinkReader)
+		if !ok1 || !ok2 {
+			return ChangeModified, true, nil
+		}
+		ltarget, err := rlayer.ReadlinkIfPossible(path)
+		if err != nil {
+			return 0, false, err
+		}
+		btarget, err := rbase.ReadlinkIfPossible(path)
+		if err != nil {
+			return 0, false, err
+		}
+		return ChangeModified, ltarget != btarget, nil
+	case lfi.Mode().IsRegular():
+		if lfi.Size() != bfi.Size() {
+			return ChangeModified, true, nil
+		}
+		same, err := sameContent(u.layer, u.base, path)
+		if err != nil {
+			return 0, false, err
+		}
+		if !same {
+			return ChangeModified, true, nil
+		}
+	}
+
+	return ChangeMode, lfi.Mode()&modeBits != bfi.Mode()&modeBits, nil
+}
+
+// sameContent reports whether name has the same content in a and b.
+func sameContent(a, b Fs, name string) (bool, error) {
+	fa, err := a.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := b.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufa, bufb := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		na, erra := io.ReadFull(fa, bufa)
+		nb, errb := io.ReadFull(fb, bufb)
+		if !bytes.Equal(bufa[:na], bufb[:nb]) {
+			return false, nil
+		}
+		enda := erra == io.EOF || erra == io.ErrUnexpectedEOF
+		endb := errb == io.EOF || errb == io.ErrUnexpectedEOF
+		switch {
+		case erra != nil && !enda:
+			return false, erra
+		case errb != nil && !endb:
+			return false, errb
+		case enda || endb:
+			return enda == endb, nil
+		}
+	}
+}
+
+// commit applies c to target.
+func (u *CopyOnWriteFs) commit(target Fs, c Change) error {
+	if c.Kind == ChangeDeleted {
+		return target.RemoveAll(c.Path)
+	}
+
+	fi, err := lstatIfPossible(u.layer, c.Path)
+	if err != nil {
+		return err
+	}
+	if c.Kind == ChangeMode {
+		return target.Chmod(c.Path, fi.Mode())
+	}
+	if c.Kind == ChangeModified {
+		// The type may have changed, and a link is not replaced in place
+		if tfi, err := lstatIfPossible(target, c.Path); err == nil &&
+			(tfi.Mode()&os.ModeType != fi.Mode()&os.ModeType || fi.Mode()&os.ModeSymlink != 0) {
+			if err := target.RemoveAll(c.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case fi.IsDir():
+		if err := target.MkdirAll(c.Path, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		return target.Chmod(c.Path, fi.Mode())
+	case fi.Mode()&os.ModeSymlink != 0:
+		rlayer, ok1 := u.layer.(LinkReader)
+		ltarget, ok2 := target.(Linker)
+		if !ok1 || !ok2 {
+			return &os.LinkError{Op: "symlink", Old: "", New: c.Path, Err: ErrNoSymlink}
+		}
+		link, err := rlayer.ReadlinkIfPossible(c.Path)
+		if err != nil {
+			return err
+		}
+		return ltarget.SymlinkIfPossible(link, c.Path)
+	}
+
+	src, err := u.layer.Open(c.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := target.OpenFile(c.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := target.Chmod(c.Path, fi.Mode()); err != nil {
+		return err
+	}
+	return target.Chtimes(c.Path, fi.ModTime(), fi.ModTime())
+}
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
 
-var _ Lstater = (*CopyOnWriteFs)(nil)
+var _ Symlinker = (*CopyOnWriteFs)(nil)
+var _ Chowner = (*CopyOnWriteFs)(nil)
 
 // The CopyOnWriteFs is a union filesystem: a read only base file system with
 // a possibly writeable layer on top. Changes to the file system will only
@@ -16,6 +18,13 @@ var _ Lstater = (*CopyOnWriteFs)(nil)
 // is not present in the overlay will copy the file to the overlay ("changing"
 // includes also calls to e.g. Chtimes() and Chmod()).
 //
+// Removing or renaming a file present in the base layer leaves a whiteout in
+// the overlay, an empty file named ".wh." followed by the name of the file,
+// which hides it from the union. A directory created where a removed one used
+// to be is made opaque with a ".wh..wh..opq" file, hiding what the base layer
+// has below it. This is the layout of OCI image layers; names starting with
+// ".wh." are reserved.
+//
 // Reading directories is currently only supported via Open(), not OpenFile().
 type CopyOnWriteFs struct {
 	base  Fs
@@ -26,11 +35,130 @@ func NewCopyOnWriteFs(base Fs, layer Fs) Fs {
 	return &CopyOnWriteFs{base: base, layer: layer}
 }
 
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// whiteoutPath returns the name of the whiteout hiding name.
+func whiteoutPath(name string) string {
+	dir, file := filepath.Split(filepath.Clean(name))
+	return filepath.Join(dir, whiteoutPrefix+file)
+}
+
+func isWhiteoutName(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), whiteoutPrefix)
+}
+
+// isHidden reports whether the overlay hides what the base layer has at
+// name. That is the case if name or one of its parents is whited out, or if
+// one of its parents is a file or an opaque directory in the overlay.
+func (u *CopyOnWriteFs) isHidden(name string) (bool, error) {
+	name = filepath.Clean(name)
+	cur := ""
+	if filepath.IsAbs(name) {
+		cur = FilePathSeparator
+	}
+	parts := strings.Split(name, FilePathSeparator)
+	for i, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+		p := filepath.Join(cur, part)
+		if ok, err := u.inLayer(whiteoutPath(p)); ok || err != nil {
+			return ok, err
+		}
+		if i == len(parts)-1 {
+			return false, nil
+		}
+		fi, err := lstatIfPossible(u.layer, p)
+		if err != nil {
+			if u.isNotExist(err) {
+				// Below a directory missing from the overlay there are no whiteouts
+				return false, nil
+			}
+			return false, err
+		}
+		if !fi.IsDir() {
+			return true, nil
+		}
+		if ok, err := u.inLayer(filepath.Join(p, whiteoutOpaque)); ok || err != nil {
+			return ok, err
+		}
+		cur = p
+	}
+	return false, nil
+}
+
+// inLayer reports whether the overlay has name, without following links.
+func (u *CopyOnWriteFs) inLayer(name string) (bool, error) {
+	_, err := lstatIfPossible(u.layer, name)
+	if err == nil {
+		return true, nil
+	}
+	if u.isNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// inBase reports whether the base layer has name and the overlay does not
+// hide it.
+func (u *CopyOnWriteFs) inBase(name string) (bool, error) {
+	if hidden, err := u.isHidden(name); hidden || err != nil {
+		return false, err
+	}
+	_, err := lstatIfPossible(u.base, name)
+	if err == nil {
+		return true, nil
+	}
+	if u.isNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// whiteout hides name, present in the base layer, from the union.
+func (u *CopyOnWriteFs) whiteout(name string) error {
+	if err := copyDirsToLayer(u.base, u.layer, filepath.Dir(name)); err != nil {
+		return err
+	}
+	f, err := u.layer.Create(whiteoutPath(name))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// replaceWhiteout is called once name has been created in the overlay. If
+// it replaced a whiteout, the whiteout is removed; a directory is made opaque
+// first, so that the content of the removed one stays hidden.
+func (u *CopyOnWriteFs) replaceWhiteout(name string) error {
+	wh := whiteoutPath(name)
+	ok, err := u.inLayer(wh)
+	if err != nil || !ok {
+		return err
+	}
+	if isaDir, _ := IsDir(u.layer, name); isaDir {
+		f, err := u.layer.Create(filepath.Join(name, whiteoutOpaque))
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return u.layer.Remove(wh)
+}
+
 // Returns true if the file is not in the overlay
 func (u *CopyOnWriteFs) isBaseFile(name string) (bool, error) {
 	if _, err := u.layer.Stat(name); err == nil {
 		return false, nil
 	}
+	if hidden, err := u.isHidden(name); hidden || err != nil {
+		return false, err
+	}
 	_, err := u.base.Stat(name)
 	if err != nil {
 		if oerr, ok := err.(*os.PathError); ok {
@@ -49,7 +177,34 @@ func (u *CopyOnWriteFs) copyToLayer(name string) error {
 	return copyToLayer(u.base, u.layer, name)
 }
 
+// followLinks resolves symbolic links in the last element of name through
+// the union, so that a change made via a link is copied up for its target
+// instead of replacing the link in the overlay.
+func (u *CopyOnWriteFs) followLinks(name string) (string, error) {
+	for hops := 0; ; hops++ {
+		fi, _, err := u.LstatIfPossible(name)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return name, nil
+		}
+		if hops == maxSymlinks {
+			return name, &os.PathError{Op: "open", Path: name, Err: syscall.ELOOP}
+		}
+		target, err := u.ReadlinkIfPossible(name)
+		if err != nil {
+			return name, err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(name), target)
+		}
+		name = target
+	}
+}
+
 func (u *CopyOnWriteFs) Chtimes(name string, atime, mtime time.Time) error {
+	name, err := u.followLinks(name)
+	if err != nil {
+		return err
+	}
 	b, err := u.isBaseFile(name)
 	if err != nil {
 		return err
@@ -63,6 +218,10 @@ func (u *CopyOnWriteFs) Chtimes(name string, atime, mtime time.Time) error {
 }
 
 func (u *CopyOnWriteFs) Chmod(name string, mode os.FileMode) error {
+	name, err := u.followLinks(name)
+	if err != nil {
+		return err
+	}
 	b, err := u.isBaseFile(name)
 	if err != nil {
 		return err
@@ -75,11 +234,84 @@ func (u *CopyOnWriteFs) Chmod(name string, mode os.FileMode) error {
 	return u.layer.Chmod(name, mode)
 }
 
+func (u *CopyOnWriteFs) Chown(name string, uid, gid int) error {
+	chowner, ok := u.layer.(Chowner)
+	if !ok {
+		return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+	}
+	name, err := u.followLinks(name)
+	if err != nil {
+		return err
+	}
+	b, err := u.isBaseFile(name)
+	if err != nil {
+		return err
+	}
+	if b {
+		if err := u.copyToLayer(name); err != nil {
+			return err
+		}
+	}
+	return chowner.Chown(name, uid, gid)
+}
+
+// Lchown of a symbolic link present only in the base layer copies the link,
+// not its target, to the overlay.
+func (u *CopyOnWriteFs) Lchown(name string, uid, gid int) error {
+	chowner, ok := u.layer.(Chowner)
+	if !ok {
+		return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+	}
+	if _, _, err := u.LstatIfPossible(name); err != nil {
+		return err
+	}
+	if _, err := lstatIfPossible(u.layer, name); err != nil {
+		if !u.isNotExist(err) {
+			return err
+		}
+		if err := u.copyLinkToLayer(name); err != nil {
+			return err
+		}
+	}
+	return chowner.Lchown(name, uid, gid)
+}
+
+// copyLinkToLayer copies name from the base layer to the overlay, which is
+// either the symbolic link itself or, for any other file, its content.
+func (u *CopyOnWriteFs) copyLinkToLayer(name string) error {
+	fi, err := lstatIfPossible(u.base, name)
+	if err != nil {
+		return err
+	}
+	rbase, ok1 := u.base.(LinkReader)
+	slayer, ok2 := u.layer.(Linker)
+	if fi.Mode()&os.ModeSymlink == 0 || !ok1 || !ok2 {
+		return u.copyToLayer(name)
+	}
+	target, err := rbase.ReadlinkIfPossible(name)
+	if err != nil {
+		return err
+	}
+	if err := copyDirsToLayer(u.base, u.layer, filepath.Dir(name)); err != nil {
+		return err
+	}
+	return slayer.SymlinkIfPossible(target, name)
+}
+
 func (u *CopyOnWriteFs) Stat(name string) (os.FileInfo, error) {
+	if isWhiteoutName(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
 	fi, err := u.layer.Stat(name)
 	if err != nil {
 		isNotExist := u.isNotExist(err)
 		if isNotExist {
+			if hidden, err := u.isHidden(name); hidden || err != nil {
+				if err == nil {
+					err = &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+				}
+				return nil, err
+			}
 			return u.base.Stat(name)
 		}
 		return nil, err
@@ -91,6 +323,10 @@ func (u *CopyOnWriteFs) LstatIfPossible(name string) (os.FileInfo, bool, error)
 	llayer, ok1 := u.layer.(Lstater)
 	lbase, ok2 := u.base.(Lstater)
 
+	if isWhiteoutName(name) {
+		return nil, ok1 || ok2, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
+
 	if ok1 {
 		fi, b, err := llayer.LstatIfPossible(name)
 		if err == nil {
@@ -102,6 +338,13 @@ func (u *CopyOnWriteFs) LstatIfPossible(name string) (os.FileInfo, bool, error)
 		}
 	}
 
+	if hidden, err := u.isHidden(name); hidden || err != nil {
+		if err == nil {
+			err = &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+		}
+		return nil, ok1 || ok2, err
+	}
+
 	if ok2 {
 		fi, b, err := lbase.LstatIfPossible(name)
 		if err == nil {
@@ -117,6 +360,50 @@ func (u *CopyOnWriteFs) LstatIfPossible(name string) (os.FileInfo, bool, error)
 	return fi, false, err
 }
 
+// Symbolic links are always created in the overlay, creating the parent
+// directory there first if it only exists in the base layer.
+func (u *CopyOnWriteFs) SymlinkIfPossible(oldname, newname string) error {
+	slayer, ok := u.layer.(Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+	}
+	if isWhiteoutName(newname) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EINVAL}
+	}
+	if _, _, err := u.LstatIfPossible(newname); err == nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	dir := filepath.Dir(newname)
+	if isaDir, _ := IsDir(u, dir); isaDir {
+		if err := copyDirsToLayer(u.base, u.layer, dir); err != nil {
+			return err
+		}
+	}
+	if err := slayer.SymlinkIfPossible(oldname, newname); err != nil {
+		return err
+	}
+	return u.replaceWhiteout(newname)
+}
+
+func (u *CopyOnWriteFs) ReadlinkIfPossible(name string) (string, error) {
+	if rlayer, ok := u.layer.(LinkReader); ok {
+		link, err := rlayer.ReadlinkIfPossible(name)
+		if err == nil || !u.isNotExist(err) {
+			return link, err
+		}
+	}
+	if hidden, err := u.isHidden(name); hidden || err != nil {
+		if err == nil {
+			err = &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+		}
+		return "", err
+	}
+	if rbase, ok := u.base.(LinkReader); ok {
+		return rbase.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
 func (u *CopyOnWriteFs) isNotExist(err error) bool {
 	if e, ok := err.(*os.PathError); ok {
 		err = e.Err
@@ -127,50 +414,167 @@ func (u *CopyOnWriteFs) isNotExist(err error) bool {
 	return false
 }
 
-// Renaming files present only in the base layer is not permitted
+// Rename moves oldname in the overlay, copying it there first, and leaves a
+// whiteout behind if it is present in the base layer.
 func (u *CopyOnWriteFs) Rename(oldname, newname string) error {
-	b, err := u.isBaseFile(oldname)
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: underlyingError(err)}
+	}
+	if isWhiteoutName(oldname) || isWhiteoutName(newname) {
+		return linkErr(syscall.EINVAL)
+	}
+	ofi, _, err := u.LstatIfPossible(oldname)
+	if err != nil {
+		return linkErr(err)
+	}
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	if oldname == newname {
+		return nil
+	}
+	if ofi.IsDir() && strings.HasPrefix(newname, oldname+FilePathSeparator) {
+		return linkErr(syscall.EINVAL)
+	}
+
+	nfi, _, err := u.LstatIfPossible(newname)
+	switch {
+	case err == nil:
+		// Replacing an existing file, as the os would
+		if nfi.IsDir() {
+			if !ofi.IsDir() {
+				return linkErr(syscall.EISDIR)
+			}
+			names, err := readDirNames(u, newname)
+			if err != nil {
+				return linkErr(err)
+			}
+			if len(names) > 0 {
+				return linkErr(syscall.ENOTEMPTY)
+			}
+		} else if ofi.IsDir() {
+			return linkErr(syscall.ENOTDIR)
+		}
+		if err := u.remove(newname); err != nil {
+			return err
+		}
+	case !u.isNotExist(err):
+		return err
+	default:
+		isaDir, err := IsDir(u, filepath.Dir(newname))
+		if err != nil {
+			return linkErr(err)
+		}
+		if !isaDir {
+			return linkErr(syscall.ENOTDIR)
+		}
+	}
+
+	inBase, err := u.inBase(oldname)
 	if err != nil {
 		return err
 	}
-	if b {
-		return syscall.EPERM
+	if err := u.copyTreeToLayer(oldname); err != nil {
+		return err
+	}
+	if err := copyDirsToLayer(u.base, u.layer, filepath.Dir(newname)); err != nil {
+		return err
 	}
-	return u.layer.Rename(oldname, newname)
+	if err := u.layer.Rename(oldname, newname); err != nil {
+		return err
+	}
+	if err := u.replaceWhiteout(newname); err != nil {
+		return err
+	}
+	if inBase {
+		return u.whiteout(oldname)
+	}
+	return nil
 }
 
-// Removing files present only in the base layer is not permitted. If
-// a file is present in the base layer and the overlay, only the overlay
-// will be removed.
+// copyTreeToLayer copies name, and all of the union below it if it is a
+// directory, to the overlay.
+func (u *CopyOnWriteFs) copyTreeToLayer(name string) error {
+	return Walk(u, name, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ok, err := u.inLayer(path); ok || err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			return copyDirsToLayer(u.base, u.layer, path)
+		case fi.Mode()&os.ModeSymlink != 0:
+			return u.copyLinkToLayer(path)
+		default:
+			return u.copyToLayer(path)
+		}
+	})
+}
+
+// Remove removes name from the overlay and leaves a whiteout if it is
+// present in the base layer. As with the os, directories have to be empty.
 func (u *CopyOnWriteFs) Remove(name string) error {
-	err := u.layer.Remove(name)
-	switch err {
-	case syscall.ENOENT:
-		_, err = u.base.Stat(name)
-		if err == nil {
-			return syscall.EPERM
+	fi, _, err := u.LstatIfPossible(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: underlyingError(err)}
+	}
+	if fi.IsDir() {
+		names, err := readDirNames(u, name)
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
 		}
-		return syscall.ENOENT
-	default:
-		return err
 	}
+	return u.remove(name)
 }
 
 func (u *CopyOnWriteFs) RemoveAll(name string) error {
-	err := u.layer.RemoveAll(name)
-	switch err {
-	case syscall.ENOENT:
-		_, err = u.base.Stat(name)
-		if err == nil {
-			return syscall.EPERM
+	if _, _, err := u.LstatIfPossible(name); err != nil {
+		if u.isNotExist(err) {
+			return nil
 		}
-		return syscall.ENOENT
-	default:
 		return err
 	}
+	return u.remove(name)
+}
+
+// remove removes name and everything below it from the union.
+func (u *CopyOnWriteFs) remove(name string) error {
+	inBase, err := u.inBase(name)
+	if err != nil {
+		return err
+	}
+	inLayer, err := u.inLayer(name)
+	if err != nil {
+		return err
+	}
+	if inLayer {
+		if err := u.layer.RemoveAll(name); err != nil {
+			return err
+		}
+	}
+	if inBase {
+		return u.whiteout(name)
+	}
+	return nil
 }
 
 func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if isWhiteoutName(name) {
+		if flag&os.O_CREATE != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EINVAL}
+		}
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		var err error
+		if name, err = u.followLinks(name); err != nil {
+			return nil, err
+		}
+	}
+
 	b, err := u.isBaseFile(name)
 	if err != nil {
 		return nil, err
@@ -185,26 +589,25 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 		}
 
 		dir := filepath.Dir(name)
-		isaDir, err := IsDir(u.base, dir)
-		if err != nil && !os.IsNotExist(err) {
-			return nil, err
+		isaDir, err := IsDir(u, dir)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: underlyingError(err)}
 		}
-		if isaDir {
-			if err = u.layer.MkdirAll(dir, 0777); err != nil {
-				return nil, err
-			}
-			return u.layer.OpenFile(name, flag, perm)
+		if !isaDir {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOTDIR}
 		}
-
-		isaDir, err = IsDir(u.layer, dir)
+		if err = copyDirsToLayer(u.base, u.layer, dir); err != nil {
+			return nil, err
+		}
+		f, err := u.layer.OpenFile(name, flag, perm)
 		if err != nil {
 			return nil, err
 		}
-		if isaDir {
-			return u.layer.OpenFile(name, flag, perm)
+		if err := u.replaceWhiteout(name); err != nil {
+			f.Close()
+			return nil, err
 		}
-
-		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOTDIR} // ...or os.ErrNotExist?
+		return f, nil
 	}
 	if b {
 		return u.base.OpenFile(name, flag, perm)
@@ -217,6 +620,10 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 //  layer: doesn't exist, exists as a file, and exists as a directory
 //  base:  doesn't exist, exists as a file, and exists as a directory
 func (u *CopyOnWriteFs) Open(name string) (File, error) {
+	if isWhiteoutName(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
 	// Since the overlay overrides the base we check that first
 	b, err := u.isBaseFile(name)
 	if err != nil {
@@ -239,13 +646,22 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 
 	// Overlay is a directory, base state now matters.
 	// Base state has 3 states to check but 2 outcomes:
-	// A. It's a file or non-readable in the base (return just the overlay)
+	// A. It's a file, non-readable or hidden in the base (return just the overlay)
 	// B. It's an accessible directory in the base (return a UnionFile)
+	// Either way the whiteouts must not show up in the listing.
 
-	// If base is file or nonreadable, return overlay
+	// If base is file, nonreadable or hidden, return overlay
+	hidden, err := u.isHidden(name)
+	if err != nil {
+		return nil, err
+	}
 	dir, err = IsDir(u.base, name)
-	if !dir || err != nil {
-		return u.layer.Open(name)
+	if !dir || err != nil || hidden {
+		lfile, err := u.layer.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &UnionFile{Layer: lfile, whiteouts: true}, nil
 	}
 
 	// Both base & layer are directories
@@ -258,18 +674,31 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 		return nil, fmt.Errorf("BaseErr: %v\nOverlayErr: %v", bErr, lErr)
 	}
 
-	return &UnionFile{Base: bfile, Layer: lfile}, nil
+	return &UnionFile{Base: bfile, Layer: lfile, whiteouts: true}, nil
 }
 
 func (u *CopyOnWriteFs) Mkdir(name string, perm os.FileMode) error {
-	dir, err := IsDir(u.base, name)
+	if isWhiteoutName(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EINVAL}
+	}
+	if _, _, err := u.LstatIfPossible(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EEXIST}
+	}
+	dir := filepath.Dir(filepath.Clean(name))
+	isaDir, err := IsDir(u, dir)
 	if err != nil {
-		return u.layer.MkdirAll(name, perm)
+		return &os.PathError{Op: "mkdir", Path: name, Err: underlyingError(err)}
 	}
-	if dir {
-		return syscall.EEXIST
+	if !isaDir {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
 	}
-	return u.layer.MkdirAll(name, perm)
+	if err := copyDirsToLayer(u.base, u.layer, dir); err != nil {
+		return err
+	}
+	if err := u.layer.Mkdir(name, perm); err != nil {
+		return err
+	}
+	return u.replaceWhiteout(name)
 }
 
 func (u *CopyOnWriteFs) Name() string {
@@ -277,14 +706,30 @@ func (u *CopyOnWriteFs) Name() string {
 }
 
 func (u *CopyOnWriteFs) MkdirAll(name string, perm os.FileMode) error {
-	dir, err := IsDir(u.base, name)
-	if err != nil {
-		return u.layer.MkdirAll(name, perm)
+	name = filepath.Clean(name)
+	fi, err := u.Stat(name)
+	if err == nil {
+		if fi.IsDir() {
+			return nil
+		}
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
 	}
-	if dir {
-		return syscall.EEXIST
+	if !u.isNotExist(err) {
+		return err
+	}
+	if dir := filepath.Dir(name); dir != name {
+		if err := u.MkdirAll(dir, perm); err != nil {
+			return err
+		}
+	}
+	if err := u.Mkdir(name, perm); err != nil {
+		// Someone else may have been faster
+		if isaDir, _ := IsDir(u, name); isaDir {
+			return nil
+		}
+		return err
 	}
-	return u.layer.MkdirAll(name, perm)
+	return nil
 }
 
 func (u *CopyOnWriteFs) Create(name string) (File, error) {
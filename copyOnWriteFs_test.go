@@ -1,6 +1,14 @@
 package afero
 
-import "testing"
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
 
 func TestCopyOnWrite(t *testing.T) {
 	var fs Fs
@@ -38,3 +46,212 @@ func TestCopyOnWriteFileInMemMapBase(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func newWhiteoutTestFs(t *testing.T, layer Fs) (Fs, Fs) {
+	base := NewMemMapFs()
+	for _, name := range []string{"/dir/a", "/dir/b", "/dir/sub/c", "/file"} {
+		if err := base.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(base, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewCopyOnWriteFs(NewReadOnlyFs(base), layer), layer
+}
+
+func checkDirNames(t *testing.T, fs Fs, dir string, want ...string) {
+	t.Helper()
+	f, err := fs.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("%s contains %v, want %v", dir, names, want)
+	}
+}
+
+func TestCopyOnWriteRemove(t *testing.T) {
+	ufs, layer := newWhiteoutTestFs(t, NewMemMapFs())
+
+	if err := ufs.Remove("/dir/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Stat("/dir/a"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a removed file returned %v", err)
+	}
+	if _, err := layer.Stat("/dir/.wh.a"); err != nil {
+		t.Errorf("no whiteout in the overlay: %v", err)
+	}
+	checkDirNames(t, ufs, "/dir", "b", "sub")
+
+	if err := ufs.Remove("/dir/sub"); err == nil {
+		t.Error("Remove of a non-empty directory succeeded")
+	}
+	if err := ufs.RemoveAll("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Stat("/dir/sub/c"); !os.IsNotExist(err) {
+		t.Errorf("Stat below a removed directory returned %v", err)
+	}
+
+	// Recreating brings back a new file, not the one of the base layer
+	if err := WriteFile(ufs, "/dir/a", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ReadFile(ufs, "/dir/a"); string(data) != "new" {
+		t.Errorf("/dir/a contains %q, want %q", data, "new")
+	}
+	if err := ufs.Mkdir("/dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	checkDirNames(t, ufs, "/dir/sub")
+	checkDirNames(t, ufs, "/dir", "a", "b", "sub")
+
+	if _, err := ufs.Create("/dir/.wh.b"); err == nil {
+		t.Error("Create of a whiteout name succeeded")
+	}
+}
+
+func TestCopyOnWriteRename(t *testing.T) {
+	osFs := NewOsFs()
+	dir, err := TempDir(osFs, "", "afero-cow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(dir)
+	ufs, _ := newWhiteoutTestFs(t, NewBasePathFs(osFs, dir))
+
+	if err := ufs.Rename("/file", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Stat("/file"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a renamed file returned %v", err)
+	}
+	if data, _ := ReadFile(ufs, "/moved"); string(data) != "/file" {
+		t.Errorf("/moved contains %q, want %q", data, "/file")
+	}
+
+	if err := ufs.Rename("/dir", "/dir2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Stat("/dir"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a renamed directory returned %v", err)
+	}
+	checkDirNames(t, ufs, "/", "dir2", "moved")
+	checkDirNames(t, ufs, "/dir2", "a", "b", "sub")
+	if data, _ := ReadFile(ufs, "/dir2/sub/c"); string(data) != "/dir/sub/c" {
+		t.Errorf("/dir2/sub/c contains %q, want %q", data, "/dir/sub/c")
+	}
+
+	// A directory renamed over a removed one does not show the old content
+	if err := ufs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	checkDirNames(t, ufs, "/dir")
+}
+
+func TestUnionFileReaddirPaging(t *testing.T) {
+	ufs, _ := newWhiteoutTestFs(t, NewMemMapFs())
+	WriteFile(ufs, "/dir/d", []byte("d"), 0644)
+	ufs.Remove("/dir/b")
+
+	f, err := ufs.Open("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	for {
+		fis, err := f.Readdir(1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fis) != 1 {
+			t.Fatalf("Readdir(1) returned %d entries", len(fis))
+		}
+		names = append(names, fis[0].Name())
+	}
+	sort.Strings(names)
+	if want := []string{"a", "d", "sub"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("paged through %v, want %v", names, want)
+	}
+}
+
+func TestCopyOnWriteChanges(t *testing.T) {
+	base := NewMemMapFs()
+	base.MkdirAll("/dir/sub", 0755)
+	for _, name := range []string{"/a", "/keep", "/same", "/dir/b", "/dir/c", "/dir/sub/d"} {
+		WriteFile(base, name, []byte(name), 0644)
+	}
+	ufs := NewCopyOnWriteFs(NewReadOnlyFs(base), NewMemMapFs()).(*CopyOnWriteFs)
+
+	ufs.Mkdir("/new", 0755)
+	WriteFile(ufs, "/new/file", []byte("new"), 0644)
+	WriteFile(ufs, "/a", []byte("changed"), 0644)
+	ufs.Chmod("/keep", 0600)
+	// Copied to the overlay, but unchanged
+	ufs.Chtimes("/same", time.Now(), time.Now())
+	ufs.Remove("/dir/b")
+	ufs.Rename("/dir/c", "/c")
+	ufs.RemoveAll("/dir/sub")
+
+	want := []Change{
+		{ChangeModified, "/a"},
+		{ChangeAdded, "/c"},
+		{ChangeDeleted, "/dir/b"},
+		{ChangeDeleted, "/dir/c"},
+		{ChangeDeleted, "/dir/sub"},
+		{ChangeMode, "/keep"},
+		{ChangeAdded, "/new"},
+		{ChangeAdded, "/new/file"},
+	}
+	changes, err := ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got changes %v, want %v", changes, want)
+	}
+
+	changes, err = ufs.Commit(base, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("dry run returned %v, want %v", changes, want)
+	}
+	if _, err := base.Stat("/new"); !os.IsNotExist(err) {
+		t.Error("dry run changed the target")
+	}
+
+	if _, err := ufs.Commit(base, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ReadFile(base, "/a"); string(data) != "changed" {
+		t.Errorf("/a contains %q after commit", data)
+	}
+	if data, _ := ReadFile(base, "/c"); string(data) != "/dir/c" {
+		t.Errorf("/c contains %q after commit", data)
+	}
+	if fi, err := base.Stat("/keep"); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Stat(/keep) after commit = %v, %v", fi, err)
+	}
+	for _, name := range []string{"/dir/b", "/dir/c", "/dir/sub", "/dir/sub/d"} {
+		if _, err := base.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s still there after commit", name)
+		}
+	}
+	if changes, err := ufs.Changes(); err != nil || len(changes) != 0 {
+		t.Errorf("changes after commit: %v, %v", changes, err)
+	}
+}
@@ -67,6 +67,20 @@ func (h HttpFs) Chmod(name string, mode os.FileMode) error {
 	return h.source.Chmod(name, mode)
 }
 
+func (h HttpFs) Chown(name string, uid, gid int) error {
+	if chowner, ok := h.source.(Chowner); ok {
+		return chowner.Chown(name, uid, gid)
+	}
+	return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+}
+
+func (h HttpFs) Lchown(name string, uid, gid int) error {
+	if chowner, ok := h.source.(Chowner); ok {
+		return chowner.Lchown(name, uid, gid)
+	}
+	return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+}
+
 func (h HttpFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return h.source.Chtimes(name, atime, mtime)
 }
@@ -0,0 +1,343 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.16
+// +build go1.16
+
+package afero
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// IOFS adapts afero.Fs to the io/fs.FS interface, so that any Afero
+// filesystem can be used with html/template.ParseFS, http.FS and friends.
+//
+// Names are slash-separated and unrooted as required by io/fs and are
+// passed on to the wrapped Fs as they are, so a MemMapFs or a BasePathFs
+// sees them relative to its root.
+type IOFS struct {
+	Fs
+}
+
+func NewIOFS(fs Fs) IOFS {
+	return IOFS{Fs: fs}
+}
+
+var (
+	_ fs.FS         = IOFS{}
+	_ fs.GlobFS     = IOFS{}
+	_ fs.ReadDirFS  = IOFS{}
+	_ fs.ReadFileFS = IOFS{}
+	_ fs.StatFS     = IOFS{}
+	_ fs.SubFS      = IOFS{}
+)
+
+func (iofs IOFS) Open(name string) (fs.File, error) {
+	const op = "open"
+
+	// by convention for fs.FS implementations we should perform this check
+	if !fs.ValidPath(name) {
+		return nil, iofs.wrapError(op, name, fs.ErrInvalid)
+	}
+
+	file, err := iofs.Fs.Open(name)
+	if err != nil {
+		return nil, iofs.wrapError(op, name, err)
+	}
+
+	// file should implement fs.ReadDirFile
+	if _, ok := file.(fs.ReadDirFile); !ok {
+		file = readDirFile{file}
+	}
+
+	return file, nil
+}
+
+func (iofs IOFS) Glob(pattern string) ([]string, error) {
+	const op = "glob"
+
+	// afero.Glob does not perform this check but it's required for implementations
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, iofs.wrapError(op, pattern, err)
+	}
+
+	items, err := Glob(iofs.Fs, pattern)
+	if err != nil {
+		return nil, iofs.wrapError(op, pattern, err)
+	}
+
+	return items, nil
+}
+
+func (iofs IOFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	const op = "readdir"
+
+	if !fs.ValidPath(name) {
+		return nil, iofs.wrapError(op, name, fs.ErrInvalid)
+	}
+
+	items, err := ReadDir(iofs.Fs, name)
+	if err != nil {
+		return nil, iofs.wrapError(op, name, err)
+	}
+
+	ret := make([]fs.DirEntry, len(items))
+	for i := range items {
+		ret[i] = fs.FileInfoToDirEntry(items[i])
+	}
+
+	return ret, nil
+}
+
+func (iofs IOFS) ReadFile(name string) ([]byte, error) {
+	const op = "readfile"
+
+	if !fs.ValidPath(name) {
+		return nil, iofs.wrapError(op, name, fs.ErrInvalid)
+	}
+
+	bytes, err := ReadFile(iofs.Fs, name)
+	if err != nil {
+		return nil, iofs.wrapError(op, name, err)
+	}
+
+	return bytes, nil
+}
+
+func (iofs IOFS) Stat(name string) (fs.FileInfo, error) {
+	const op = "stat"
+
+	if !fs.ValidPath(name) {
+		return nil, iofs.wrapError(op, name, fs.ErrInvalid)
+	}
+
+	fi, err := iofs.Fs.Stat(name)
+	if err != nil {
+		return nil, iofs.wrapError(op, name, err)
+	}
+
+	return fi, nil
+}
+
+func (iofs IOFS) Sub(dir string) (fs.FS, error) {
+	const op = "sub"
+
+	if !fs.ValidPath(dir) {
+		return nil, iofs.wrapError(op, dir, fs.ErrInvalid)
+	}
+	if dir == "." {
+		return iofs, nil
+	}
+
+	isDir, err := IsDir(iofs.Fs, dir)
+	if err != nil {
+		return nil, iofs.wrapError(op, dir, err)
+	}
+	if !isDir {
+		return nil, iofs.wrapError(op, dir, syscall.ENOTDIR)
+	}
+
+	return IOFS{Fs: NewBasePathFs(iofs.Fs, dir)}, nil
+}
+
+func (IOFS) wrapError(op, path string, err error) error {
+	if _, ok := err.(*fs.PathError); ok {
+		return err // don't need to wrap again
+	}
+
+	return &fs.PathError{
+		Op:   op,
+		Path: path,
+		Err:  err,
+	}
+}
+
+// readDirFile provides adapter from afero.File to fs.ReadDirFile needed for
+// correct Open
+type readDirFile struct {
+	File
+}
+
+var _ fs.ReadDirFile = readDirFile{}
+
+func (r readDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	items, err := r.File.Readdir(n)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]fs.DirEntry, len(items))
+	for i := range items {
+		ret[i] = fs.FileInfoToDirEntry(items[i])
+	}
+
+	return ret, nil
+}
+
+// FromIOFS adapts an io/fs.FS, for example an embed.FS, to a read-only
+// afero.Fs. All mutating methods return syscall.EPERM, as ReadOnlyFs does.
+//
+// Afero names are cleaned and made relative before they are handed to the
+// io/fs.FS, so "/dir/file", "dir/file" and "./dir/file" all refer to the same
+// file.
+type FromIOFS struct {
+	fs.FS
+}
+
+var _ Fs = FromIOFS{}
+
+func NewFromIOFS(fsys fs.FS) Fs {
+	return FromIOFS{FS: fsys}
+}
+
+// ioName translates an afero name into the unrooted, slash-separated form
+// io/fs expects.
+func (f FromIOFS) ioName(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	if name == "/" {
+		return "."
+	}
+	return name[1:]
+}
+
+func (f FromIOFS) Create(name string) (File, error) { return nil, syscall.EPERM }
+
+func (f FromIOFS) Mkdir(name string, perm os.FileMode) error { return syscall.EPERM }
+
+func (f FromIOFS) MkdirAll(path string, perm os.FileMode) error { return syscall.EPERM }
+
+func (f FromIOFS) Open(name string) (File, error) {
+	file, err := f.FS.Open(f.ioName(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return fromIOFSFile{File: file, name: name}, nil
+}
+
+func (f FromIOFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, syscall.EPERM
+	}
+	return f.Open(name)
+}
+
+func (f FromIOFS) Remove(name string) error { return syscall.EPERM }
+
+func (f FromIOFS) RemoveAll(path string) error { return syscall.EPERM }
+
+func (f FromIOFS) Rename(oldname, newname string) error { return syscall.EPERM }
+
+func (f FromIOFS) Stat(name string) (os.FileInfo, error) { return fs.Stat(f.FS, f.ioName(name)) }
+
+func (f FromIOFS) Name() string { return "fromiofs" }
+
+func (f FromIOFS) Chmod(name string, mode os.FileMode) error { return syscall.EPERM }
+
+func (f FromIOFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return syscall.EPERM
+}
+
+// fromIOFSFile adapts an fs.File to afero.File. Seeking and reading at an
+// offset depend on the underlying file supporting it, writing always fails.
+type fromIOFSFile struct {
+	fs.File
+	name string
+}
+
+func (f fromIOFSFile) ReadAt(p []byte, off int64) (n int, err error) {
+	readerAt, ok := f.File.(io.ReaderAt)
+	if !ok {
+		return 0, syscall.EPERM
+	}
+
+	return readerAt.ReadAt(p, off)
+}
+
+func (f fromIOFSFile) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := f.File.(io.Seeker)
+	if !ok {
+		return -1, syscall.EPERM
+	}
+
+	return seeker.Seek(offset, whence)
+}
+
+func (f fromIOFSFile) Write(p []byte) (n int, err error) {
+	return 0, syscall.EPERM
+}
+
+func (f fromIOFSFile) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, syscall.EPERM
+}
+
+func (f fromIOFSFile) Name() string { return f.name }
+
+func (f fromIOFSFile) Readdir(count int) ([]os.FileInfo, error) {
+	rdfile, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, syscall.ENOTDIR
+	}
+
+	entries, err := rdfile.ReadDir(count)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]os.FileInfo, len(entries))
+	for i := range entries {
+		ret[i], err = entries[i].Info()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+func (f fromIOFSFile) Readdirnames(n int) ([]string, error) {
+	rdfile, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, syscall.ENOTDIR
+	}
+
+	entries, err := rdfile.ReadDir(n)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, len(entries))
+	for i := range entries {
+		ret[i] = entries[i].Name()
+	}
+
+	return ret, nil
+}
+
+func (f fromIOFSFile) Sync() error { return nil }
+
+func (f fromIOFSFile) Truncate(size int64) error {
+	return syscall.EPERM
+}
+
+func (f fromIOFSFile) WriteString(s string) (ret int, err error) {
+	return 0, syscall.EPERM
+}
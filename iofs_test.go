@@ -0,0 +1,279 @@
+//go:build go1.16
+// +build go1.16
+
+package afero
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIOFS(t *testing.T) {
+	t.Run("use MemMapFs", func(t *testing.T) {
+		mmfs := NewMemMapFs()
+
+		err := mmfs.MkdirAll("/dir1/dir2", os.ModePerm)
+		if err != nil {
+			t.Fatal("MkdirAll failed:", err)
+		}
+
+		f, err := mmfs.OpenFile("/dir1/dir2/test.txt", os.O_RDWR|os.O_CREATE, os.ModePerm)
+		if err != nil {
+			t.Fatal("OpenFile (O_CREATE) failed:", err)
+		}
+
+		f.Close()
+
+		if err := fstest.TestFS(NewIOFS(mmfs), "dir1/dir2/test.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("use OsFs", func(t *testing.T) {
+		osfs := NewBasePathFs(NewOsFs(), testDir(NewOsFs()))
+		defer removeAllTestFiles(t)
+
+		err := osfs.MkdirAll("/dir1/dir2", os.ModePerm)
+		if err != nil {
+			t.Fatal("MkdirAll failed:", err)
+		}
+
+		f, err := osfs.OpenFile("/dir1/dir2/test.txt", os.O_RDWR|os.O_CREATE, os.ModePerm)
+		if err != nil {
+			t.Fatal("OpenFile (O_CREATE) failed:", err)
+		}
+
+		f.Close()
+
+		if err := fstest.TestFS(NewIOFS(osfs), "dir1/dir2/test.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestIOFSNativeDirEntryWhenPossible(t *testing.T) {
+	osfs := NewBasePathFs(NewOsFs(), testDir(NewOsFs()))
+	defer removeAllTestFiles(t)
+
+	err := osfs.MkdirAll("/dir1/dir2", os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const numFiles = 10
+
+	var fileNumbers []int
+	for i := 0; i < numFiles; i++ {
+		fileNumbers = append(fileNumbers, i)
+	}
+	for _, i := range fileNumbers {
+		f, err := osfs.Create(filepath.Join("dir1/dir2", string(rune('a'+i))+".txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	dir2, err := NewIOFS(osfs).Open("dir1/dir2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir2.Close()
+
+	entries, err := dir2.(fs.ReadDirFile).ReadDir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != numFiles {
+		t.Fatalf("ReadDir returned %d entries, want %d", len(entries), numFiles)
+	}
+}
+
+func TestFromIOFS(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"test.txt": {
+			Data: []byte("File in root"),
+		},
+		"dir1": {
+			Mode: fs.ModeDir,
+		},
+		"dir1/dir2": {
+			Mode: fs.ModeDir,
+		},
+		"dir1/dir2/hello.txt": {
+			Data: []byte("Hello world"),
+		},
+	}
+
+	fromIOFS := NewFromIOFS(fsys)
+
+	t.Run("Create", func(t *testing.T) {
+		_, err := fromIOFS.Create("test")
+		assertPermissionError(t, err)
+	})
+
+	t.Run("Mkdir", func(t *testing.T) {
+		err := fromIOFS.Mkdir("test", 0)
+		assertPermissionError(t, err)
+	})
+
+	t.Run("MkdirAll", func(t *testing.T) {
+		err := fromIOFS.Mkdir("test", 0)
+		assertPermissionError(t, err)
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		t.Run("non existing file", func(t *testing.T) {
+			_, err := fromIOFS.Open("nonexisting")
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Expected error to be fs.ErrNotExist, got %[1]T (%[1]v)", err)
+			}
+		})
+
+		t.Run("directory", func(t *testing.T) {
+			dirFile, err := fromIOFS.Open("/dir1")
+			if err != nil {
+				t.Errorf("dir1 open failed: %v", err)
+				return
+			}
+
+			defer dirFile.Close()
+
+			dirStat, err := dirFile.Stat()
+			if err != nil {
+				t.Errorf("dir1 stat failed: %v", err)
+				return
+			}
+
+			if !dirStat.IsDir() {
+				t.Errorf("dir1 stat told that it is not a directory")
+				return
+			}
+
+			names, err := dirFile.Readdirnames(-1)
+			if err != nil {
+				t.Errorf("dir1 Readdirnames failed: %v", err)
+				return
+			}
+			if len(names) != 1 || names[0] != "dir2" {
+				t.Errorf("dir1 Readdirnames returned %v", names)
+			}
+		})
+
+		t.Run("simple file", func(t *testing.T) {
+			file, err := fromIOFS.Open("test.txt")
+			if err != nil {
+				t.Errorf("test.txt open failed: %v", err)
+				return
+			}
+
+			defer file.Close()
+
+			fileStat, err := file.Stat()
+			if err != nil {
+				t.Errorf("test.txt stat failed: %v", err)
+				return
+			}
+
+			if fileStat.IsDir() {
+				t.Errorf("test.txt stat told that it is a directory")
+				return
+			}
+
+			data, err := io.ReadAll(file)
+			if err != nil {
+				t.Errorf("test.txt read failed: %v", err)
+				return
+			}
+			if !bytes.Equal(data, []byte("File in root")) {
+				t.Errorf("test.txt contains %q", data)
+			}
+
+			if _, err := file.Write([]byte("data")); !errors.Is(err, syscall.EPERM) {
+				t.Errorf("test.txt write returned %v", err)
+			}
+		})
+	})
+
+	t.Run("OpenFile", func(t *testing.T) {
+		_, err := fromIOFS.OpenFile("test.txt", os.O_RDWR, 0)
+		assertPermissionError(t, err)
+
+		file, err := fromIOFS.OpenFile("dir1/dir2/hello.txt", os.O_RDONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		err := fromIOFS.Remove("test")
+		assertPermissionError(t, err)
+	})
+
+	t.Run("RemoveAll", func(t *testing.T) {
+		err := fromIOFS.RemoveAll("test")
+		assertPermissionError(t, err)
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		err := fromIOFS.Rename("test", "test2")
+		assertPermissionError(t, err)
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		for _, name := range []string{"dir1/dir2/hello.txt", "/dir1/dir2/hello.txt", "./dir1/../dir1/dir2/hello.txt"} {
+			fi, err := fromIOFS.Stat(name)
+			if err != nil {
+				t.Errorf("Stat(%q) failed: %v", name, err)
+				continue
+			}
+			if fi.Size() != int64(len("Hello world")) {
+				t.Errorf("Stat(%q) size is %d", name, fi.Size())
+			}
+		}
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		var visited []string
+		err := Walk(fromIOFS, "/", func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			visited = append(visited, path)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(visited) != 5 {
+			t.Errorf("Walk visited %v", visited)
+		}
+	})
+
+	t.Run("Chmod", func(t *testing.T) {
+		err := fromIOFS.Chmod("test", os.ModePerm)
+		assertPermissionError(t, err)
+	})
+}
+
+func assertPermissionError(t *testing.T, err error) {
+	t.Helper()
+
+	var perr *fs.PathError
+	if errors.As(err, &perr) {
+		err = perr.Err
+	}
+	if err != syscall.EPERM {
+		t.Errorf("Expected syscall.EPERM, got %[1]T (%[1]v)", err)
+	}
+}
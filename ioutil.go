@@ -102,7 +102,12 @@ func readAll(r io.Reader, capacity int64) (b []byte, err error) {
 			panic(e)
 		}
 	}()
-	_, err = buf.ReadFrom(r)
+	// A file copying itself out, through parallel requests, is preferred
+	if wt, ok := r.(io.WriterTo); ok {
+		_, err = wt.WriteTo(buf)
+	} else {
+		_, err = buf.ReadFrom(r)
+	}
 	return buf.Bytes(), err
 }
 
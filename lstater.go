@@ -14,6 +14,7 @@
 package afero
 
 import (
+	"errors"
 	"os"
 )
 
@@ -25,3 +26,48 @@ import (
 type Lstater interface {
 	LstatIfPossible(name string) (os.FileInfo, bool, error)
 }
+
+// Symlinker is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It indicates support for 3 symlink related interfaces that implement the
+// behaviors of the os methods:
+//   - Lstat
+//   - Symlink, and
+//   - Readlink
+type Symlinker interface {
+	Lstater
+	Linker
+	LinkReader
+}
+
+// Linker is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It will call Symlink if the filesystem itself is, or it delegates to, a
+// filesystem that supports symbolic links.
+// Else it will return an *os.LinkError wrapping ErrNoSymlink.
+type Linker interface {
+	SymlinkIfPossible(oldname, newname string) error
+}
+
+// maxSymlinks is the number of symbolic links followed while resolving a
+// single path before giving up with ELOOP, as on Linux.
+const maxSymlinks = 40
+
+// ErrNoSymlink is the error that will be wrapped in an os.LinkError if a file
+// system does not support symlinks either directly or through its delegated
+// filesystem.
+var ErrNoSymlink = errors.New("symlink not supported")
+
+// LinkReader is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It will call Readlink if the filesystem itself is, or it delegates to, a
+// filesystem that supports symbolic links.
+// Else it will return an *os.PathError wrapping ErrNoReadlink.
+type LinkReader interface {
+	ReadlinkIfPossible(name string) (string, error)
+}
+
+// ErrNoReadlink is the error that will be wrapped in an os.PathError if a
+// file system does not support the readlink operation either directly or
+// through its delegated filesystem.
+var ErrNoReadlink = errors.New("readlink not supported")
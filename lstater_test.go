@@ -17,6 +17,7 @@ package afero
 import (
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
 )
 
@@ -49,9 +50,14 @@ func TestLstatIfPossible(t *testing.T) {
 	roFsMem := &ReadOnlyFs{source: memFs}
 
 	pathFileMem := filepath.Join(memWorkDir, "aferom.txt")
+	pathSymlinkMem := filepath.Join(memWorkDir, "symaferom.txt")
 
 	WriteFile(osFs, filepath.Join(workDir, "afero.txt"), []byte("Hi, Afero!"), 0777)
+	memFs.MkdirAll(memWorkDir, 0777)
 	WriteFile(memFs, filepath.Join(pathFileMem), []byte("Hi, Afero!"), 0777)
+	if err := memFs.(Linker).SymlinkIfPossible("aferom.txt", pathSymlinkMem); err != nil {
+		t.Fatal(err)
+	}
 
 	os.Chdir(workDir)
 	if err := os.Symlink("afero.txt", "symafero.txt"); err != nil {
@@ -95,8 +101,166 @@ func TestLstatIfPossible(t *testing.T) {
 	testLstat(overlayFs1, pathFile, pathSymlink)
 	testLstat(overlayFs2, pathFile, pathSymlink)
 	testLstat(basePathFs, "afero.txt", "symafero.txt")
-	testLstat(overlayFsMemOnly, pathFileMem, "")
-	testLstat(basePathFsMem, "aferom.txt", "")
+	testLstat(overlayFsMemOnly, pathFileMem, pathSymlinkMem)
+	testLstat(basePathFsMem, "aferom.txt", "symaferom.txt")
 	testLstat(roFs, pathFile, pathSymlink)
-	testLstat(roFsMem, pathFileMem, "")
+	testLstat(roFsMem, pathFileMem, pathSymlinkMem)
+}
+
+func TestSymlinkIfPossible(t *testing.T) {
+	osFs := &OsFs{}
+
+	workDir, err := TempDir(osFs, "", "afero-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		osFs.RemoveAll(workDir)
+	}()
+
+	memWorkDir := "/symlink"
+
+	memFs := NewMemMapFs()
+	memFs.MkdirAll(memWorkDir, 0777)
+	overlayFs := &CopyOnWriteFs{base: &ReadOnlyFs{source: memFs}, layer: NewMemMapFs()}
+	basePathFs := &BasePathFs{source: osFs, path: workDir}
+	basePathFsMem := &BasePathFs{source: memFs, path: memWorkDir}
+
+	testSymlink := func(fs Fs, dir string) {
+		name := filepath.Join(dir, "file.txt")
+		link := filepath.Join(dir, "link.txt")
+		fs.MkdirAll(dir, 0777)
+		if err := WriteFile(fs, name, []byte("Hi, Afero!"), 0644); err != nil {
+			t.Fatalf("%s: %s", fs.Name(), err)
+		}
+		if err := fs.(Linker).SymlinkIfPossible("file.txt", link); err != nil {
+			t.Fatalf("%s: Symlink failed: %s", fs.Name(), err)
+		}
+		if err := fs.(Linker).SymlinkIfPossible("file.txt", link); err == nil {
+			t.Errorf("%s: Symlink over an existing file should fail", fs.Name())
+		}
+
+		target, err := fs.(LinkReader).ReadlinkIfPossible(link)
+		if err != nil {
+			t.Fatalf("%s: Readlink failed: %s", fs.Name(), err)
+		}
+		if target != "file.txt" {
+			t.Errorf("%s: Readlink returned %q, want %q", fs.Name(), target, "file.txt")
+		}
+		if _, err := fs.(LinkReader).ReadlinkIfPossible(name); err == nil {
+			t.Errorf("%s: Readlink of a regular file should fail", fs.Name())
+		}
+
+		content, err := ReadFile(fs, link)
+		if err != nil {
+			t.Fatalf("%s: reading through link failed: %s", fs.Name(), err)
+		}
+		if string(content) != "Hi, Afero!" {
+			t.Errorf("%s: read %q through link", fs.Name(), content)
+		}
+		fi, err := fs.Stat(link)
+		if err != nil {
+			t.Fatalf("%s: Stat failed: %s", fs.Name(), err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 || fi.Name() != "link.txt" {
+			t.Errorf("%s: Stat should describe the target under the link name, got %s %v", fs.Name(), fi.Name(), fi.Mode())
+		}
+
+		if err := fs.Remove(link); err != nil {
+			t.Fatalf("%s: Remove of link failed: %s", fs.Name(), err)
+		}
+		if _, err := fs.Stat(name); err != nil {
+			t.Errorf("%s: removing the link removed the target: %s", fs.Name(), err)
+		}
+	}
+
+	testSymlink(osFs, workDir)
+	testSymlink(memFs, memWorkDir)
+	testSymlink(overlayFs, memWorkDir)
+	testSymlink(basePathFs, "/sub")
+	testSymlink(basePathFsMem, "/sub")
+
+	if err := basePathFs.SymlinkIfPossible("../../etc/passwd", "/escape"); err == nil {
+		t.Error("BasePathFs: relative link out of the base should fail")
+	}
+	if err := basePathFs.SymlinkIfPossible("/abs.txt", "/abs"); err != nil {
+		t.Fatal(err)
+	}
+	if target, _ := basePathFs.ReadlinkIfPossible("/abs"); target != "/abs.txt" {
+		t.Errorf("BasePathFs: Readlink returned %q, want %q", target, "/abs.txt")
+	}
+	if target, _ := osFs.ReadlinkIfPossible(filepath.Join(workDir, "abs")); target != filepath.Join(workDir, "abs.txt") {
+		t.Errorf("BasePathFs: link was created with target %q", target)
+	}
+
+	if err := (&ReadOnlyFs{source: memFs}).SymlinkIfPossible("a", "b"); err == nil {
+		t.Error("ReadOnlyFs: Symlink should fail")
+	}
+}
+
+func TestMemMapFsSymlinkResolution(t *testing.T) {
+	fs := NewMemMapFs()
+	lfs := fs.(Symlinker)
+
+	fs.MkdirAll("/real/dir", 0777)
+	WriteFile(fs, "/real/dir/file.txt", []byte("content"), 0644)
+
+	if err := lfs.SymlinkIfPossible("/real", "/abs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lfs.SymlinkIfPossible("real/dir", "/rel"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/abs/dir/file.txt", "/rel/file.txt"} {
+		content, err := ReadFile(fs, name)
+		if err != nil {
+			t.Errorf("reading %s failed: %s", name, err)
+			continue
+		}
+		if string(content) != "content" {
+			t.Errorf("read %q from %s", content, name)
+		}
+	}
+
+	// Files created through a link end up in the target directory
+	if err := WriteFile(fs, "/rel/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/real/dir/new.txt"); err != nil {
+		t.Errorf("file created through link not found in target: %s", err)
+	}
+	names, err := readDirNames(fs, "/rel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "file.txt" || names[1] != "new.txt" {
+		t.Errorf("Readdir through link returned %v", names)
+	}
+
+	// Lstat describes the link itself
+	fi, _, err := lfs.LstatIfPossible("/abs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat mode is %v, want a symlink", fi.Mode())
+	}
+
+	// Dangling links can be read but not followed
+	lfs.SymlinkIfPossible("/nowhere", "/dangling")
+	if _, err := fs.Stat("/dangling"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a dangling link returned %v", err)
+	}
+	if _, _, err := lfs.LstatIfPossible("/dangling"); err != nil {
+		t.Errorf("Lstat of a dangling link failed: %s", err)
+	}
+
+	// Loops are detected
+	lfs.SymlinkIfPossible("/loop2", "/loop1")
+	lfs.SymlinkIfPossible("/loop1", "/loop2")
+	_, err = fs.Open("/loop1")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.ELOOP {
+		t.Errorf("Open of a symlink loop returned %v, want ELOOP", err)
+	}
 }
@@ -0,0 +1,157 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+// chunkSize is the size of the chunks the content of a file is stored in.
+const chunkSize = 64 << 10
+
+// chunks is the content of a file, stored in chunks of chunkSize bytes.
+// A chunk missing from the map is a hole reading as zeros, and a chunk
+// only holds the bytes up to the last one written, the rest of it reading
+// as zeros as well: writing at some offset allocates around it, a Truncate
+// to a larger size allocates nothing.
+//
+// The chunks of a copy are shared with the original until either writes
+// to them: once shared is set, a chunk is copied before it is written to,
+// unless it is in owned, having been written to since.
+type chunks struct {
+	size      int64
+	allocated int64
+	chunks    map[int64][]byte
+	shared    bool
+	owned     map[int64]bool
+}
+
+// clone returns a copy of c sharing its chunks.
+func (c *chunks) clone() chunks {
+	m := make(map[int64][]byte, len(c.chunks))
+	for i, chunk := range c.chunks {
+		m[i] = chunk
+	}
+	c.shared, c.owned = true, nil
+	return chunks{size: c.size, allocated: c.allocated, chunks: m, shared: true}
+}
+
+// own makes the chunk i of c its own, copying it if it may be shared, and
+// grows it to size bytes, within its capacity if it has enough.
+func (c *chunks) own(i int64, size int64) []byte {
+	chunk := c.chunks[i]
+	copied := c.shared && !c.owned[i]
+	if copied {
+		if c.owned == nil {
+			c.owned = make(map[int64]bool)
+		}
+		c.owned[i] = true
+	}
+	if size < int64(len(chunk)) {
+		size = int64(len(chunk))
+	}
+	var grown []byte
+	switch {
+	case !copied && int64(len(chunk)) == size:
+		return chunk
+	case !copied && int64(cap(chunk)) >= size:
+		grown = chunk[:size]
+		// a truncate may have left content past the length
+		for j := len(chunk); j < len(grown); j++ {
+			grown[j] = 0
+		}
+	default:
+		grown = make([]byte, size, growCap(cap(chunk), size))
+		copy(grown, chunk)
+	}
+	c.allocated += size - int64(len(chunk))
+	c.chunks[i] = grown
+	return grown
+}
+
+// readAt copies the content at off to b, up to the end of the file, and
+// returns the number of bytes copied.
+func (c *chunks) readAt(b []byte, off int64) int {
+	if off >= c.size {
+		return 0
+	}
+	if rest := c.size - off; int64(len(b)) > rest {
+		b = b[:rest]
+	}
+	for n := 0; n < len(b); {
+		i, start := (off+int64(n))/chunkSize, (off+int64(n))%chunkSize
+		end := start + int64(len(b)-n)
+		if end > chunkSize {
+			end = chunkSize
+		}
+		dst := b[n : n+int(end-start)]
+		chunk := c.chunks[i]
+		copied := 0
+		if start < int64(len(chunk)) {
+			copied = copy(dst, chunk[start:])
+		}
+		for j := copied; j < len(dst); j++ {
+			dst[j] = 0
+		}
+		n += len(dst)
+	}
+	return len(b)
+}
+
+// writeAt writes b at off, extending the file if needed.
+func (c *chunks) writeAt(b []byte, off int64) {
+	if c.chunks == nil {
+		c.chunks = make(map[int64][]byte)
+	}
+	for n := 0; n < len(b); {
+		i, start := (off+int64(n))/chunkSize, (off+int64(n))%chunkSize
+		end := start + int64(len(b)-n)
+		if end > chunkSize {
+			end = chunkSize
+		}
+		chunk := c.own(i, end)
+		n += copy(chunk[start:end], b[n:])
+	}
+	if end := off + int64(len(b)); end > c.size {
+		c.size = end
+	}
+}
+
+// growCap returns the capacity of a chunk of size bytes growing from old,
+// doubling up to chunkSize.
+func growCap(old int, size int64) int64 {
+	c := int64(2 * old)
+	if c < size {
+		c = size
+	}
+	if c > chunkSize {
+		c = chunkSize
+	}
+	return c
+}
+
+// truncate changes the size of the file, dropping the content past size.
+func (c *chunks) truncate(size int64) {
+	if size < c.size {
+		for i, chunk := range c.chunks {
+			start := i * chunkSize
+			switch {
+			case start >= size:
+				c.allocated -= int64(len(chunk))
+				delete(c.chunks, i)
+				delete(c.owned, i)
+			case start+int64(len(chunk)) > size:
+				c.allocated -= start + int64(len(chunk)) - size
+				c.chunks[i] = chunk[:size-start]
+			}
+		}
+	}
+	c.size = size
+}
@@ -13,10 +13,12 @@
 
 package mem
 
+// Dir holds the entries of a directory, by their base name.
 type Dir interface {
 	Len() int
 	Names() []string
 	Files() []*FileData
+	Get(name string) (*FileData, bool)
 	Add(*FileData)
 	Remove(*FileData)
 }
@@ -35,3 +37,51 @@ func InitializeDir(d *FileData) {
 		d.memDir = &DirMap{}
 	}
 }
+
+// FindInMemDir returns the entry of dir with the base name name. It fails if
+// dir is not a directory. A frozen entry is replaced by a thawed copy first,
+// unless dir is frozen too.
+func FindInMemDir(dir *FileData, name string) (*FileData, bool) {
+	dir.Lock()
+	defer dir.Unlock()
+	if dir.memDir == nil {
+		return nil, false
+	}
+	f, ok := dir.memDir.Get(name)
+	if ok && f.frozen && !dir.frozen {
+		f = Thaw(f)
+		dir.memDir.Add(f)
+	}
+	return f, ok
+}
+
+// MemDirLen returns the number of entries of dir, 0 if dir is not a
+// directory.
+func MemDirLen(dir *FileData) int {
+	dir.Lock()
+	defer dir.Unlock()
+	if dir.memDir == nil {
+		return 0
+	}
+	return dir.memDir.Len()
+}
+
+// MemDirFiles returns the entries of dir sorted by name, none if dir is not
+// a directory. The frozen entries are thawed as by FindInMemDir.
+func MemDirFiles(dir *FileData) []*FileData {
+	dir.Lock()
+	defer dir.Unlock()
+	if dir.memDir == nil {
+		return nil
+	}
+	files := dir.memDir.Files()
+	if !dir.frozen {
+		for i, f := range files {
+			if f.frozen {
+				files[i] = Thaw(f)
+				dir.memDir.Add(files[i])
+			}
+		}
+	}
+	return files
+}
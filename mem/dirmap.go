@@ -13,13 +13,26 @@
 
 package mem
 
-import "sort"
+import (
+	"path/filepath"
+	"sort"
+)
 
+// DirMap is a Dir keyed by the base names of the entries.
 type DirMap map[string]*FileData
 
-func (m DirMap) Len() int           { return len(m) }
-func (m DirMap) Add(f *FileData)    { m[f.name] = f }
-func (m DirMap) Remove(f *FileData) { delete(m, f.name) }
+func (m DirMap) Len() int        { return len(m) }
+func (m DirMap) Add(f *FileData) { m[filepath.Base(f.name)] = f }
+func (m DirMap) Remove(f *FileData) {
+	if name := filepath.Base(f.name); m[name] == f {
+		delete(m, name)
+	}
+}
+
+func (m DirMap) Get(name string) (*FileData, bool) {
+	f, ok := m[name]
+	return f, ok
+}
 func (m DirMap) Files() (files []*FileData) {
 	for _, f := range m {
 		files = append(files, f)
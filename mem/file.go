@@ -15,26 +15,32 @@
 package mem
 
 import (
-	"bytes"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"syscall"
 )
 
 import "time"
 
 const FilePathSeparator = string(filepath.Separator)
 
+// File is a handle on a FileData. Like a file descriptor it has its own
+// offset, used and moved by Read, Write and Seek but not by ReadAt and
+// WriteAt, and its own access mode.
 type File struct {
 	// atomic requires 64-bit alignment for struct field access
 	at           int64
 	readDirCount int64
 	closed       bool
 	readOnly     bool
+	writeOnly    bool
+	append       bool
 	fileData     *FileData
+	onWrite      func(name string)
 }
 
 func NewFileHandle(data *FileData) *File {
@@ -45,6 +51,32 @@ func NewReadOnlyFileHandle(data *FileData) *File {
 	return &File{fileData: data, readOnly: true}
 }
 
+// OpenFileHandle returns a handle on data with the access mode of flag, one
+// of os.O_RDONLY, os.O_WRONLY and os.O_RDWR, and appending every write if
+// flag has os.O_APPEND. The other bits of flag are left to the caller.
+func OpenFileHandle(data *FileData, flag int) *File {
+	f := &File{fileData: data, append: flag&os.O_APPEND != 0}
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		f.readOnly = true
+	case os.O_WRONLY:
+		f.writeOnly = true
+	}
+	return f
+}
+
+// SetWriteNotify makes f call fn with the name of its file after every
+// change made through it with Write, WriteAt or Truncate.
+func SetWriteNotify(f *File, fn func(name string)) {
+	f.onWrite = fn
+}
+
+func (f *File) notifyWrite() {
+	if f.onWrite != nil {
+		f.onWrite(f.fileData.Name())
+	}
+}
+
 func (f File) Data() *FileData {
 	return f.fileData
 }
@@ -52,11 +84,17 @@ func (f File) Data() *FileData {
 type FileData struct {
 	sync.Mutex
 	name    string
-	data    []byte
+	data    chunks
 	memDir  Dir
 	dir     bool
 	mode    os.FileMode
 	modtime time.Time
+	target  string
+	uid     int
+	gid     int
+	// frozen is set on the entries of a snapshot, which are shared between
+	// trees and never changed: an entry is thawed before it is used.
+	frozen bool
 }
 
 func (d *FileData) Name() string {
@@ -66,11 +104,73 @@ func (d *FileData) Name() string {
 }
 
 func CreateFile(name string) *FileData {
-	return &FileData{name: name, mode: os.ModeTemporary, modtime: time.Now()}
+	return &FileData{name: name, mode: os.ModeTemporary, modtime: time.Now(), uid: os.Getuid(), gid: os.Getgid()}
 }
 
 func CreateDir(name string) *FileData {
-	return &FileData{name: name, memDir: &DirMap{}, dir: true}
+	return &FileData{name: name, memDir: &DirMap{}, dir: true, mode: os.ModeDir | 0755, uid: os.Getuid(), gid: os.Getgid()}
+}
+
+func CreateSymlink(name string, target string) *FileData {
+	return &FileData{name: name, mode: os.ModeSymlink | 0777, modtime: time.Now(), target: target, uid: os.Getuid(), gid: os.Getgid()}
+}
+
+// IsSymlink reports whether f is a symbolic link.
+func IsSymlink(f *FileData) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.mode&os.ModeSymlink != 0
+}
+
+// SymlinkTarget returns the path f points to, or an empty string if f is
+// not a symbolic link.
+func SymlinkTarget(f *FileData) string {
+	f.Lock()
+	defer f.Unlock()
+	return f.target
+}
+
+// FreezeTree returns a copy of f and of the entries below it which is never
+// changed afterwards. The copy shares the content of the files with f until
+// either is written to, and shares the entries already frozen, so freezing
+// a tree again only copies what changed since.
+func FreezeTree(f *FileData) *FileData {
+	if f.frozen {
+		return f
+	}
+	f.Lock()
+	c := &FileData{name: f.name, data: f.data.clone(), dir: f.dir, mode: f.mode,
+		modtime: f.modtime, target: f.target, uid: f.uid, gid: f.gid, frozen: true}
+	var children []*FileData
+	if f.memDir != nil {
+		c.memDir = &DirMap{}
+		children = f.memDir.Files()
+	}
+	f.Unlock()
+	for _, child := range children {
+		c.memDir.Add(FreezeTree(child))
+	}
+	return c
+}
+
+// Thaw returns f if it can be changed, else a copy of f which can. Only f is
+// copied, its entries stay shared with the frozen tree until looked up
+// through FindInMemDir or MemDirFiles.
+func Thaw(f *FileData) *FileData {
+	if !f.frozen {
+		return f
+	}
+	f.Lock()
+	defer f.Unlock()
+	c := &FileData{name: f.name, data: f.data.clone(), dir: f.dir, mode: f.mode,
+		modtime: f.modtime, target: f.target, uid: f.uid, gid: f.gid}
+	if f.memDir != nil {
+		c.memDir = &DirMap{}
+		for _, child := range f.memDir.Files() {
+			c.memDir.Add(child)
+		}
+	}
+	return c
 }
 
 func ChangeFileName(f *FileData, newname string) {
@@ -85,6 +185,18 @@ func SetMode(f *FileData, mode os.FileMode) {
 	f.Unlock()
 }
 
+func SetOwner(f *FileData, uid, gid int) {
+	f.Lock()
+	// -1 leaves the id unchanged, as with os.Chown
+	if uid != -1 {
+		f.uid = uid
+	}
+	if gid != -1 {
+		f.gid = gid
+	}
+	f.Unlock()
+}
+
 func SetModTime(f *FileData, mtime time.Time) {
 	f.Lock()
 	setModTime(f, mtime)
@@ -134,6 +246,10 @@ func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
 	var outLength int64
 
 	f.fileData.Lock()
+	if !f.fileData.dir {
+		f.fileData.Unlock()
+		return nil, &os.PathError{Op: "readdir", Path: f.fileData.name, Err: syscall.ENOTDIR}
+	}
 	files := f.fileData.memDir.Files()[f.readDirCount:]
 	if count > 0 {
 		if len(files) < count {
@@ -173,91 +289,135 @@ func (f *File) Read(b []byte) (n int, err error) {
 	if f.closed == true {
 		return 0, ErrFileClosed
 	}
-	if len(b) > 0 && int(f.at) == len(f.fileData.data) {
-		return 0, io.EOF
-	}
-	if int(f.at) > len(f.fileData.data) {
-		return 0, io.ErrUnexpectedEOF
+	if f.writeOnly {
+		return 0, &os.PathError{Op: "read", Path: f.fileData.name, Err: errors.New("file handle is write only")}
 	}
-	if len(f.fileData.data)-int(f.at) >= len(b) {
-		n = len(b)
-	} else {
-		n = len(f.fileData.data) - int(f.at)
+	at := atomic.LoadInt64(&f.at)
+	if len(b) > 0 && at >= f.fileData.data.size {
+		return 0, io.EOF
 	}
-	copy(b, f.fileData.data[f.at:f.at+int64(n)])
-	atomic.AddInt64(&f.at, int64(n))
+	n = f.fileData.data.readAt(b, at)
+	atomic.StoreInt64(&f.at, at+int64(n))
 	return
 }
 
+// ReadAt reads at off, leaving the offset of f alone. It is safe to call
+// from several goroutines.
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
-	atomic.StoreInt64(&f.at, off)
-	return f.Read(b)
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	if f.closed == true {
+		return 0, ErrFileClosed
+	}
+	if f.writeOnly {
+		return 0, &os.PathError{Op: "read", Path: f.fileData.name, Err: errors.New("file handle is write only")}
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.fileData.name, Err: errors.New("negative offset")}
+	}
+	n = f.fileData.data.readAt(b, off)
+	if n < len(b) {
+		err = io.EOF
+	}
+	return
 }
 
 func (f *File) Truncate(size int64) error {
+	f.fileData.Lock()
 	if f.closed == true {
+		f.fileData.Unlock()
 		return ErrFileClosed
 	}
 	if f.readOnly {
+		f.fileData.Unlock()
 		return &os.PathError{Op: "truncate", Path: f.fileData.name, Err: errors.New("file handle is read only")}
 	}
 	if size < 0 {
+		f.fileData.Unlock()
 		return ErrOutOfRange
 	}
-	if size > int64(len(f.fileData.data)) {
-		diff := size - int64(len(f.fileData.data))
-		f.fileData.data = append(f.fileData.data, bytes.Repeat([]byte{00}, int(diff))...)
-	} else {
-		f.fileData.data = f.fileData.data[0:size]
-	}
+	f.fileData.data.truncate(size)
 	setModTime(f.fileData, time.Now())
+	f.fileData.Unlock()
+	f.notifyWrite()
 	return nil
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
 	if f.closed == true {
 		return 0, ErrFileClosed
 	}
 	switch whence {
-	case 0:
-		atomic.StoreInt64(&f.at, offset)
-	case 1:
-		atomic.AddInt64(&f.at, int64(offset))
-	case 2:
-		atomic.StoreInt64(&f.at, int64(len(f.fileData.data))+offset)
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += atomic.LoadInt64(&f.at)
+	case io.SeekEnd:
+		offset += f.fileData.data.size
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.fileData.name, Err: syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.fileData.name, Err: syscall.EINVAL}
 	}
-	return f.at, nil
+	atomic.StoreInt64(&f.at, offset)
+	return offset, nil
 }
 
+// Write writes at the offset of f, or at the end of the file if f was
+// opened with os.O_APPEND, and moves the offset past the bytes written.
 func (f *File) Write(b []byte) (n int, err error) {
-	if f.readOnly {
-		return 0, &os.PathError{Op: "write", Path: f.fileData.name, Err: errors.New("file handle is read only")}
-	}
-	n = len(b)
-	cur := atomic.LoadInt64(&f.at)
 	f.fileData.Lock()
-	defer f.fileData.Unlock()
-	diff := cur - int64(len(f.fileData.data))
-	var tail []byte
-	if n+int(cur) < len(f.fileData.data) {
-		tail = f.fileData.data[n+int(cur):]
-	}
-	if diff > 0 {
-		f.fileData.data = append(bytes.Repeat([]byte{00}, int(diff)), b...)
-		f.fileData.data = append(f.fileData.data, tail...)
-	} else {
-		f.fileData.data = append(f.fileData.data[:cur], b...)
-		f.fileData.data = append(f.fileData.data, tail...)
+	if err := f.checkWrite("write"); err != nil {
+		f.fileData.Unlock()
+		return 0, err
+	}
+	at := atomic.LoadInt64(&f.at)
+	if f.append {
+		at = f.fileData.data.size
 	}
+	f.fileData.data.writeAt(b, at)
 	setModTime(f.fileData, time.Now())
-
-	atomic.StoreInt64(&f.at, int64(len(f.fileData.data)))
-	return
+	atomic.StoreInt64(&f.at, at+int64(len(b)))
+	f.fileData.Unlock()
+	f.notifyWrite()
+	return len(b), nil
 }
 
+// WriteAt writes at off, leaving the offset of f alone. As with an
+// *os.File, it fails if f was opened with os.O_APPEND.
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
-	atomic.StoreInt64(&f.at, off)
-	return f.Write(b)
+	f.fileData.Lock()
+	if err := f.checkWrite("writeat"); err != nil {
+		f.fileData.Unlock()
+		return 0, err
+	}
+	if f.append {
+		f.fileData.Unlock()
+		return 0, &os.PathError{Op: "writeat", Path: f.fileData.name, Err: errors.New("invalid use of WriteAt on file opened with O_APPEND")}
+	}
+	if off < 0 {
+		f.fileData.Unlock()
+		return 0, &os.PathError{Op: "writeat", Path: f.fileData.name, Err: errors.New("negative offset")}
+	}
+	f.fileData.data.writeAt(b, off)
+	setModTime(f.fileData, time.Now())
+	f.fileData.Unlock()
+	f.notifyWrite()
+	return len(b), nil
+}
+
+// checkWrite tells why f cannot be written to, if so. It is called with
+// f.fileData locked.
+func (f *File) checkWrite(op string) error {
+	if f.closed == true {
+		return ErrFileClosed
+	}
+	if f.readOnly {
+		return &os.PathError{Op: op, Path: f.fileData.name, Err: errors.New("file handle is read only")}
+	}
+	return nil
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
@@ -272,6 +432,15 @@ type FileInfo struct {
 	*FileData
 }
 
+// Stat is the underlying data returned by FileInfo.Sys.
+type Stat struct {
+	Uid int
+	Gid int
+	// Allocated is the number of bytes of memory holding the content of the
+	// file, less than its size if it has holes.
+	Allocated int64
+}
+
 // Implements os.FileInfo
 func (s *FileInfo) Name() string {
 	s.Lock()
@@ -294,14 +463,21 @@ func (s *FileInfo) IsDir() bool {
 	defer s.Unlock()
 	return s.dir
 }
-func (s *FileInfo) Sys() interface{} { return nil }
+func (s *FileInfo) Sys() interface{} {
+	s.Lock()
+	defer s.Unlock()
+	return &Stat{Uid: s.uid, Gid: s.gid, Allocated: s.data.allocated}
+}
 func (s *FileInfo) Size() int64 {
 	if s.IsDir() {
 		return int64(42)
 	}
 	s.Lock()
 	defer s.Unlock()
-	return int64(len(s.data))
+	if s.mode&os.ModeSymlink != 0 {
+		return int64(len(s.target))
+	}
+	return s.data.size
 }
 
 var (
@@ -1,6 +1,10 @@
 package mem
 
 import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -93,6 +97,35 @@ func TestFileDataModeRace(t *testing.T) {
 	}
 }
 
+func TestFileDataOwnerRace(t *testing.T) {
+	t.Parallel()
+
+	d := FileData{
+		uid: 1000,
+		gid: 1000,
+	}
+
+	s := FileInfo{
+		FileData: &d,
+	}
+
+	if st := s.Sys().(*Stat); st.Uid != 1000 || st.Gid != 1000 {
+		t.Errorf("Failed to read correct value, was %v", st)
+	}
+
+	SetOwner(&d, 42, -1)
+	if st := s.Sys().(*Stat); st.Uid != 42 || st.Gid != 1000 {
+		t.Errorf("Failed to set owner, was %v", st)
+	}
+
+	go func() {
+		SetOwner(&d, 1000, 1000)
+	}()
+
+	//just logging the value to trigger a read:
+	t.Logf("Value is %v", s.Sys())
+}
+
 func TestFileDataIsDirRace(t *testing.T) {
 	t.Parallel()
 
@@ -125,9 +158,9 @@ func TestFileDataSizeRace(t *testing.T) {
 	const someOtherDataSize = "Hello World"
 
 	d := FileData{
-		data: []byte(someData),
-		dir:  false,
+		dir: false,
 	}
+	d.data.writeAt([]byte(someData), 0)
 
 	s := FileInfo{
 		FileData: &d,
@@ -139,7 +172,7 @@ func TestFileDataSizeRace(t *testing.T) {
 
 	go func() {
 		s.Lock()
-		d.data = []byte(someOtherDataSize)
+		d.data.writeAt([]byte(someOtherDataSize), 0)
 		s.Unlock()
 	}()
 
@@ -152,3 +185,168 @@ func TestFileDataSizeRace(t *testing.T) {
 		t.Errorf("Failed to read correct value for dir, was %v", s.Size())
 	}
 }
+
+func TestFileSparse(t *testing.T) {
+	t.Parallel()
+
+	f := NewFileHandle(CreateFile("sparse"))
+	const size = 10 << 30
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	allocated := func() int64 {
+		fi, _ := f.Stat()
+		return fi.Sys().(*Stat).Allocated
+	}
+	if fi, _ := f.Stat(); fi.Size() != size || allocated() != 0 {
+		t.Fatalf("got size %d, %d bytes allocated, want %d and none", fi.Size(), allocated(), size)
+	}
+
+	// A write across two chunks in the middle of the file
+	data := bytes.Repeat([]byte("x"), 100)
+	off := int64(5*chunkSize - 50)
+	if _, err := f.WriteAt(data, off); err != nil {
+		t.Fatal(err)
+	}
+	if allocated() > 2*chunkSize {
+		t.Errorf("%d bytes allocated for a write of 100", allocated())
+	}
+
+	buf := make([]byte, 200)
+	if _, err := f.ReadAt(buf, off-50); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append(make([]byte, 50), data...), make([]byte, 50)...)
+	if !bytes.Equal(buf, want) {
+		t.Errorf("read %q around the write", buf)
+	}
+
+	// Shrinking drops the content, growing again reads zeros
+	if err := f.Truncate(off + 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(off + 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ReadAt(buf[:100], off); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	want = append(bytes.Repeat([]byte("x"), 10), make([]byte, 90)...)
+	if !bytes.Equal(buf[:100], want) {
+		t.Errorf("read %q after the truncation", buf[:100])
+	}
+	if allocated() > chunkSize {
+		t.Errorf("got %d bytes allocated, want at most the chunk left", allocated())
+	}
+}
+
+func TestChunksAppend(t *testing.T) {
+	var c chunks
+	reallocs := 0
+	for off := int64(0); off < 1000; off++ {
+		before := cap(c.chunks[0])
+		c.writeAt([]byte{'x'}, off)
+		if cap(c.chunks[0]) != before {
+			reallocs++
+		}
+	}
+	if reallocs > 11 {
+		t.Errorf("1000 appends of a byte reallocated the chunk %d times", reallocs)
+	}
+
+	// The bytes a truncate left past the length read as zeros once grown over
+	c.truncate(1)
+	c.writeAt([]byte{'y'}, 3)
+	buf := make([]byte, 4)
+	c.readAt(buf, 0)
+	if string(buf) != "x\x00\x00y" {
+		t.Errorf("read %q after truncating and writing past the end", buf)
+	}
+	if c.allocated != 4 {
+		t.Errorf("got %d bytes allocated, want 4", c.allocated)
+	}
+}
+
+func TestFileHandles(t *testing.T) {
+	t.Parallel()
+
+	data := CreateFile("handles")
+	w := OpenFileHandle(data, os.O_WRONLY)
+	w.WriteString("0123456789")
+
+	// ReadAt neither uses nor moves the offset, so handles sharing data
+	// can read concurrently
+	r := OpenFileHandle(data, os.O_RDONLY)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b := make([]byte, 1)
+			if _, err := r.ReadAt(b, int64(i)); err != nil || b[0] != byte('0'+i) {
+				t.Errorf("ReadAt %d: got %q, %v", i, b, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if off, _ := r.Seek(0, io.SeekCurrent); off != 0 {
+		t.Errorf("ReadAt moved the offset to %d", off)
+	}
+
+	// Appends go to the end whatever the offset and whatever was written
+	// through other handles
+	a := OpenFileHandle(data, os.O_WRONLY|os.O_APPEND)
+	a.Seek(0, io.SeekStart)
+	w.WriteString("ab")
+	a.WriteString("cd")
+	if _, err := a.WriteAt([]byte("x"), 0); err == nil {
+		t.Error("WriteAt on an O_APPEND handle succeeded")
+	}
+	b := make([]byte, 20)
+	n, _ := r.Read(b)
+	if got := string(b[:n]); got != "0123456789abcd" {
+		t.Errorf("read %q, want %q", got, "0123456789abcd")
+	}
+
+	if _, err := w.Read(b); err == nil {
+		t.Error("Read on a write only handle succeeded")
+	}
+	if _, err := r.Write(b); err == nil {
+		t.Error("Write on a read only handle succeeded")
+	}
+}
+
+func TestFreezeTree(t *testing.T) {
+	root := CreateDir("/")
+	for _, name := range []string{"/a", "/b"} {
+		d := CreateDir(name)
+		AddToMemDir(root, d)
+		f := CreateFile(name + "/f")
+		f.data.writeAt([]byte("frozen"), 0)
+		AddToMemDir(d, f)
+	}
+	frozen := FreezeTree(root)
+
+	// Only the path looked up is copied, the rest stays shared
+	live := Thaw(frozen)
+	a, _ := FindInMemDir(live, "a")
+	f, _ := FindInMemDir(a, "f")
+	f.data.writeAt([]byte("thawed"), 0)
+	if b, _ := live.memDir.Get("b"); !b.frozen {
+		t.Error("/b was copied by a lookup of /a/f")
+	}
+	buf := make([]byte, 6)
+	fa, _ := frozen.memDir.Get("a")
+	ff, _ := fa.memDir.Get("f")
+	ff.data.readAt(buf, 0)
+	if string(buf) != "frozen" {
+		t.Errorf("the frozen /a/f reads %q after a write to its thawed copy", buf)
+	}
+
+	// Freezing again only copies what was thawed
+	again := FreezeTree(live)
+	fb, _ := frozen.memDir.Get("b")
+	if b, _ := again.memDir.Get("b"); b != fb {
+		t.Error("/b was copied by a second FreezeTree")
+	}
+}
@@ -20,41 +20,142 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/afero/mem"
 )
 
+var _ Symlinker = (*MemMapFs)(nil)
+var _ Chowner = (*MemMapFs)(nil)
+var _ Watcher = (*MemMapFs)(nil)
+
+// The MemMapFs keeps its files in a tree of mem.FileData rooted at the root
+// directory, every directory holding its entries by base name. Looking a
+// path up takes one step per element, and RemoveAll and Rename of a
+// directory take time proportional to the tree below it.
 type MemMapFs struct {
 	mu   sync.RWMutex
-	data map[string]*mem.FileData
+	root *mem.FileData
 	init sync.Once
+
+	// identity is the process identity permissions are checked against,
+	// nil if they are not enforced.
+	identity *memIdentity
+
+	wmu     sync.Mutex
+	watches map[*memWatch]struct{}
 }
 
 func NewMemMapFs() Fs {
 	return &MemMapFs{}
 }
 
-func (m *MemMapFs) getData() map[string]*mem.FileData {
+func (m *MemMapFs) getRoot() *mem.FileData {
 	m.init.Do(func() {
-		m.data = make(map[string]*mem.FileData)
 		// Root should always exist, right?
 		// TODO: what about windows?
-		m.data[FilePathSeparator] = mem.CreateDir(FilePathSeparator)
+		m.root = mem.CreateDir(FilePathSeparator)
 	})
-	return m.data
+	return m.root
+}
+
+// lockfreeGet returns the entry at name, a normalized path.
+func (m *MemMapFs) lockfreeGet(name string) (*mem.FileData, bool) {
+	f := m.getRoot()
+	for _, part := range strings.Split(name, FilePathSeparator) {
+		if part == "" {
+			continue
+		}
+		var ok bool
+		if f, ok = mem.FindInMemDir(f, part); !ok {
+			return nil, false
+		}
+	}
+	return f, true
 }
 
 func (*MemMapFs) Name() string { return "MemMapFS" }
 
 func (m *MemMapFs) Create(name string) (File, error) {
-	name = normalizePath(name)
 	m.mu.Lock()
-	file := mem.CreateFile(name)
-	m.getData()[name] = file
-	m.registerWithParent(file)
+	resolved, err := m.lockfreeResolve(name, true)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if f, ok := m.lockfreeGet(resolved); ok && mem.GetFileInfo(f).IsDir() {
+		m.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	} else if ok && !m.lockfreeCan(f, permWrite) {
+		m.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	} else if err := m.lockfreeCheckEntry(resolved); err != nil {
+		m.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	file := mem.CreateFile(resolved)
+	m.lockfreeNewEntry(file, 0666)
+	if err := m.registerWithParent(file); err != nil {
+		m.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
 	m.mu.Unlock()
-	return mem.NewFileHandle(file), nil
+	m.notify(resolved, Create)
+	return m.newFileHandle(file, os.O_RDWR), nil
+}
+
+// newFileHandle returns a handle on f opened with flag that reports its
+// writes to the watches.
+func (m *MemMapFs) newFileHandle(f *mem.FileData, flag int) *mem.File {
+	h := mem.OpenFileHandle(f, flag)
+	mem.SetWriteNotify(h, func(name string) { m.notify(name, Write) })
+	return h
+}
+
+// lockfreeResolve follows the symbolic links in name and returns the path of
+// the entry it refers to. The last element is only followed if followLast is
+// set; Lstat, Remove and Rename act on the link itself.
+// Elements that do not exist are left as they are, the caller will notice.
+func (m *MemMapFs) lockfreeResolve(name string, followLast bool) (string, error) {
+	name = normalizePath(name)
+	orig, hops := name, 0
+resolve:
+	for {
+		parts := strings.Split(name, FilePathSeparator)
+		cur := ""
+		if filepath.IsAbs(name) {
+			cur = FilePathSeparator
+		}
+		dir := m.getRoot()
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			next := filepath.Join(cur, part)
+			f, ok := mem.FindInMemDir(dir, part)
+			if !ok {
+				return name, nil
+			}
+			last := i == len(parts)-1
+			if mem.IsSymlink(f) && (followLast || !last) {
+				if hops++; hops > maxSymlinks {
+					return orig, syscall.ELOOP
+				}
+				target := mem.SymlinkTarget(f)
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(cur, target)
+				}
+				name = normalizePath(filepath.Join(append([]string{target}, parts[i+1:]...)...))
+				continue resolve
+			}
+			cur, dir = next, f
+		}
+		return name, nil
+	}
 }
 
 func (m *MemMapFs) unRegisterWithParent(fileName string) error {
@@ -83,78 +184,100 @@ func (m *MemMapFs) findParent(f *mem.FileData) *mem.FileData {
 	return pfile
 }
 
-func (m *MemMapFs) registerWithParent(f *mem.FileData) {
-	if f == nil {
-		return
-	}
-	parent := m.findParent(f)
-	if parent == nil {
-		pdir := filepath.Dir(filepath.Clean(f.Name()))
-		err := m.lockfreeMkdir(pdir, 0777)
-		if err != nil {
-			//log.Println("Mkdir error:", err)
-			return
-		}
-		parent, err = m.lockfreeOpen(pdir)
-		if err != nil {
-			//log.Println("Open after Mkdir error:", err)
-			return
-		}
+// registerWithParent adds f to the directory it is in. As with the
+// os package, the directory is not created: it fails with ErrFileNotFound if
+// it is missing and with syscall.ENOTDIR if it is not a directory.
+func (m *MemMapFs) registerWithParent(f *mem.FileData) error {
+	parent, err := m.lockfreeParent(f.Name())
+	if err != nil {
+		return err
 	}
-
 	parent.Lock()
-	mem.InitializeDir(parent)
 	mem.AddToMemDir(parent, f)
 	parent.Unlock()
+	return nil
 }
 
-func (m *MemMapFs) lockfreeMkdir(name string, perm os.FileMode) error {
-	name = normalizePath(name)
-	x, ok := m.getData()[name]
-	if ok {
-		// Only return ErrFileExists if it's a file, not a directory.
-		i := mem.FileInfo{FileData: x}
-		if !i.IsDir() {
-			return ErrFileExists
+// lockfreeParent returns the directory name, a resolved path, is in.
+func (m *MemMapFs) lockfreeParent(name string) (*mem.FileData, error) {
+	f := m.getRoot()
+	for _, part := range strings.Split(filepath.Dir(name), FilePathSeparator) {
+		if part == "" {
+			continue
+		}
+		if !mem.GetFileInfo(f).IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+		var ok bool
+		if f, ok = mem.FindInMemDir(f, part); !ok {
+			return nil, ErrFileNotFound
 		}
-	} else {
-		item := mem.CreateDir(name)
-		m.getData()[name] = item
-		m.registerWithParent(item)
 	}
-	return nil
+	if !mem.GetFileInfo(f).IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+	return f, nil
 }
 
-func (m *MemMapFs) Mkdir(name string, perm os.FileMode) error {
-	name = normalizePath(name)
-
-	m.mu.RLock()
-	_, ok := m.getData()[name]
-	m.mu.RUnlock()
-	if ok {
+func (m *MemMapFs) lockfreeMkdir(name string, perm os.FileMode) error {
+	resolved, err := m.lockfreeResolve(name, false)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if _, ok := m.lockfreeGet(resolved); ok {
 		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
 	}
 
-	m.mu.Lock()
-	item := mem.CreateDir(name)
-	m.getData()[name] = item
-	m.registerWithParent(item)
-	m.mu.Unlock()
-
-	m.Chmod(name, perm|os.ModeDir)
+	if err := m.lockfreeCheckEntry(resolved); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
 
+	item := mem.CreateDir(resolved)
+	m.lockfreeNewEntry(item, perm)
+	if err := m.registerWithParent(item); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	m.notify(resolved, Create)
 	return nil
 }
 
+func (m *MemMapFs) Mkdir(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lockfreeMkdir(name, perm)
+}
+
 func (m *MemMapFs) MkdirAll(path string, perm os.FileMode) error {
-	err := m.Mkdir(path, perm)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lockfreeMkdirAll(normalizePath(path), perm)
+}
+
+// lockfreeMkdirAll creates the directory name and the missing directories
+// above it, following symbolic links as os.MkdirAll does.
+func (m *MemMapFs) lockfreeMkdirAll(name string, perm os.FileMode) error {
+	resolved, err := m.lockfreeResolve(name, true)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
 	if err != nil {
-		if err.(*os.PathError).Err == ErrFileExists {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if f, ok := m.lockfreeGet(resolved); ok {
+		if mem.GetFileInfo(f).IsDir() {
 			return nil
 		}
-		return err
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
 	}
-	return nil
+	if parent := filepath.Dir(name); parent != name {
+		if err := m.lockfreeMkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	return m.lockfreeMkdir(name, perm)
 }
 
 // Handle some relative paths
@@ -167,41 +290,51 @@ func normalizePath(path string) string {
 	case "..":
 		return FilePathSeparator
 	default:
-		return path
+		return filepath.Join(FilePathSeparator, path)
 	}
 }
 
 func (m *MemMapFs) Open(name string) (File, error) {
-	f, err := m.open(name)
+	f, err := m.open(name, permRead)
 	if f != nil {
 		return mem.NewReadOnlyFileHandle(f), err
 	}
 	return nil, err
 }
 
-func (m *MemMapFs) openWrite(name string) (File, error) {
-	f, err := m.open(name)
-	if f != nil {
-		return mem.NewFileHandle(f), err
-	}
-	return nil, err
-}
-
-func (m *MemMapFs) open(name string) (*mem.FileData, error) {
-	name = normalizePath(name)
-
+// open returns the file at name, checking that it can be accessed as want.
+func (m *MemMapFs) open(name string, want os.FileMode) (*mem.FileData, error) {
 	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
+	defer m.mu.RUnlock()
+	resolved, err := m.lockfreeResolve(name, true)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f, ok := m.lockfreeGet(resolved)
 	if !ok {
-		return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileNotFound}
+		return nil, &os.PathError{Op: "open", Path: name, Err: m.lockfreeNotFound(resolved)}
+	}
+	if !m.lockfreeCan(f, want) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
 	}
 	return f, nil
 }
 
+// lockfreeNotFound tells why name, a resolved path, was not found: as with
+// the os package, an element of its path may not be a directory.
+func (m *MemMapFs) lockfreeNotFound(name string) error {
+	if _, err := m.lockfreeParent(name); err == syscall.ENOTDIR {
+		return err
+	}
+	return ErrFileNotFound
+}
+
 func (m *MemMapFs) lockfreeOpen(name string) (*mem.FileData, error) {
 	name = normalizePath(name)
-	f, ok := m.getData()[name]
+	f, ok := m.lockfreeGet(name)
 	if ok {
 		return f, nil
 	} else {
@@ -210,149 +343,468 @@ func (m *MemMapFs) lockfreeOpen(name string) (*mem.FileData, error) {
 }
 
 func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
-	chmod := false
-	file, err := m.openWrite(name)
-	if os.IsNotExist(err) && (flag&os.O_CREATE > 0) {
-		file, err = m.Create(name)
-		chmod = true
-	}
+	m.mu.Lock()
+	data, created, err := m.lockfreeOpenFile(name, flag, perm)
+	m.mu.Unlock()
 	if err != nil {
 		return nil, err
 	}
-	if flag == os.O_RDONLY {
-		file = mem.NewReadOnlyFileHandle(file.(*mem.File).Data())
+	if created {
+		m.notify(data.Name(), Create)
 	}
-	if flag&os.O_APPEND > 0 {
-		_, err = file.Seek(0, os.SEEK_END)
+	file := m.newFileHandle(data, flag)
+	if flag&os.O_TRUNC > 0 && flag&(os.O_RDWR|os.O_WRONLY) > 0 && !created {
+		err = file.Truncate(0)
 		if err != nil {
 			file.Close()
 			return nil, err
 		}
 	}
-	if flag&os.O_TRUNC > 0 && flag&(os.O_RDWR|os.O_WRONLY) > 0 {
-		err = file.Truncate(0)
-		if err != nil {
-			file.Close()
-			return nil, err
+	return file, nil
+}
+
+// lockfreeOpenFile returns the file OpenFile opens, creating it if flag
+// asks for it, and whether it was created.
+func (m *MemMapFs) lockfreeOpenFile(name string, flag int, perm os.FileMode) (*mem.FileData, bool, error) {
+	// With O_EXCL even a dangling symbolic link is in the way
+	excl := flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL
+	resolved, err := m.lockfreeResolve(name, !excl)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		return nil, false, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if f, ok := m.lockfreeGet(resolved); ok {
+		if excl {
+			return nil, false, &os.PathError{Op: "open", Path: name, Err: ErrFileExists}
+		}
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 && mem.GetFileInfo(f).IsDir() {
+			return nil, false, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
 		}
+		if !m.lockfreeCan(f, openAccess(flag)) {
+			return nil, false, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+		}
+		return f, false, nil
 	}
-	if chmod {
-		m.Chmod(name, perm)
+	if flag&os.O_CREATE == 0 {
+		return nil, false, &os.PathError{Op: "open", Path: name, Err: m.lockfreeNotFound(resolved)}
 	}
-	return file, nil
+	if err := m.lockfreeCheckEntry(resolved); err != nil {
+		return nil, false, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f := mem.CreateFile(resolved)
+	// Not through Chmod, a new file is only reported as created
+	m.lockfreeNewEntry(f, perm)
+	if err := m.registerWithParent(f); err != nil {
+		return nil, false, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, true, nil
 }
 
 func (m *MemMapFs) Remove(name string) error {
-	name = normalizePath(name)
-
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, ok := m.getData()[name]; ok {
-		err := m.unRegisterWithParent(name)
+	resolved, err := m.lockfreeResolve(name, false)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	if f, ok := m.lockfreeGet(resolved); ok {
+		if err := m.lockfreeCheckEntry(resolved); err != nil {
+			return &os.PathError{Op: "remove", Path: name, Err: err}
+		}
+		if mem.MemDirLen(f) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+		err := m.unRegisterWithParent(resolved)
 		if err != nil {
 			return &os.PathError{Op: "remove", Path: name, Err: err}
 		}
-		delete(m.getData(), name)
 	} else {
 		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
 	}
+	m.notify(resolved, Remove)
 	return nil
 }
 
 func (m *MemMapFs) RemoveAll(path string) error {
-	path = normalizePath(path)
 	m.mu.Lock()
-	m.unRegisterWithParent(path)
+	resolved, err := m.lockfreeResolve(path, false)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+	f, ok := m.lockfreeGet(resolved)
+	if !ok {
+		m.mu.Unlock()
+		return nil
+	}
+	err = m.lockfreeCheckTree(f)
+	if err == nil && f != m.getRoot() {
+		err = m.lockfreeCheckEntry(resolved)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+	// The root itself stays, only its entries go
+	var removed []*mem.FileData
+	if f == m.getRoot() {
+		removed = mem.MemDirFiles(f)
+		for _, child := range removed {
+			f.Lock()
+			mem.RemoveFromMemDir(f, child)
+			f.Unlock()
+		}
+	} else {
+		m.unRegisterWithParent(resolved)
+		removed = []*mem.FileData{f}
+	}
+	var names []string
+	for _, f := range removed {
+		names = appendTreeNames(names, f)
+	}
 	m.mu.Unlock()
 
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	for p, _ := range m.getData() {
-		if strings.HasPrefix(p, path) {
-			m.mu.RUnlock()
-			m.mu.Lock()
-			delete(m.getData(), p)
-			m.mu.Unlock()
-			m.mu.RLock()
-		}
+	for _, name := range names {
+		m.notify(name, Remove)
 	}
 	return nil
 }
 
+// appendTreeNames appends the names of f and of the entries below it to
+// names, the entries before their directory.
+func appendTreeNames(names []string, f *mem.FileData) []string {
+	for _, child := range mem.MemDirFiles(f) {
+		names = appendTreeNames(names, child)
+	}
+	return append(names, f.Name())
+}
+
+// renameTree names f newname, and the entries below it after it.
+func renameTree(f *mem.FileData, newname string) {
+	for _, child := range mem.MemDirFiles(f) {
+		renameTree(child, filepath.Join(newname, filepath.Base(child.Name())))
+	}
+	mem.ChangeFileName(f, newname)
+}
+
+// Rename replaces newname if it exists, as os.Rename does: a directory
+// only replaces an empty directory, and a file anything but a directory.
 func (m *MemMapFs) Rename(oldname, newname string) error {
-	oldname = normalizePath(oldname)
-	newname = normalizePath(newname)
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	if oldname == newname {
-		return nil
+	oldpath, err := m.lockfreeResolve(oldname, false)
+	if err == nil {
+		err = m.lockfreeCheckPath(oldpath)
+	}
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	newpath, err := m.lockfreeResolve(newname, false)
+	if err == nil {
+		err = m.lockfreeCheckPath(newpath)
+	}
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: newname, Err: err}
 	}
 
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	if _, ok := m.getData()[oldname]; ok {
-		m.mu.RUnlock()
-		m.mu.Lock()
-		m.unRegisterWithParent(oldname)
-		fileData := m.getData()[oldname]
-		delete(m.getData(), oldname)
-		mem.ChangeFileName(fileData, newname)
-		m.getData()[newname] = fileData
-		m.registerWithParent(fileData)
-		m.mu.Unlock()
-		m.mu.RLock()
-	} else {
+	if oldpath == newpath {
+		return nil
+	}
+	if strings.HasPrefix(newpath, oldpath+FilePathSeparator) || oldpath == FilePathSeparator {
+		return &os.PathError{Op: "rename", Path: oldname, Err: syscall.EINVAL}
+	}
+	fileData, ok := m.lockfreeGet(oldpath)
+	if !ok {
 		return &os.PathError{Op: "rename", Path: oldname, Err: ErrFileNotFound}
 	}
+	if _, err := m.lockfreeParent(newpath); err != nil {
+		return &os.PathError{Op: "rename", Path: newname, Err: err}
+	}
+	if err := m.lockfreeCheckEntry(oldpath); err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	if err := m.lockfreeCheckEntry(newpath); err != nil {
+		return &os.PathError{Op: "rename", Path: newname, Err: err}
+	}
+	if target, ok := m.lockfreeGet(newpath); ok {
+		isDir, targetIsDir := mem.GetFileInfo(fileData).IsDir(), mem.GetFileInfo(target).IsDir()
+		switch {
+		case isDir && !targetIsDir:
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTDIR}
+		case !isDir && targetIsDir:
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.EISDIR}
+		case targetIsDir && mem.MemDirLen(target) > 0:
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTEMPTY}
+		}
+		if err := m.unRegisterWithParent(newpath); err != nil {
+			return &os.PathError{Op: "rename", Path: newname, Err: err}
+		}
+	}
+	if err := m.unRegisterWithParent(oldpath); err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	renameTree(fileData, newpath)
+	if err := m.registerWithParent(fileData); err != nil {
+		renameTree(fileData, oldpath)
+		m.registerWithParent(fileData)
+		return &os.PathError{Op: "rename", Path: newname, Err: err}
+	}
+	m.notify(oldpath, Rename)
+	m.notify(newpath, Create)
 	return nil
 }
 
 func (m *MemMapFs) Stat(name string) (os.FileInfo, error) {
-	f, err := m.Open(name)
+	f, err := m.open(name, 0)
 	if err != nil {
 		return nil, err
 	}
-	fi := mem.GetFileInfo(f.(*mem.File).Data())
+	fi := mem.GetFileInfo(f)
+	// Through a symbolic link the FileInfo is named after the link, as with os.Stat
+	if _, base := filepath.Split(normalizePath(name)); base != fi.Name() {
+		return &linkedFileInfo{FileInfo: fi, name: base}, nil
+	}
 	return fi, nil
 }
 
-func (m *MemMapFs) Chmod(name string, mode os.FileMode) error {
-	name = normalizePath(name)
+func (m *MemMapFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	m.mu.RLock()
+	resolved, err := m.lockfreeResolve(name, false)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		m.mu.RUnlock()
+		return nil, true, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	f, ok := m.lockfreeGet(resolved)
+	m.mu.RUnlock()
+	if !ok {
+		return nil, true, &os.PathError{Op: "lstat", Path: name, Err: ErrFileNotFound}
+	}
+	return mem.GetFileInfo(f), true, nil
+}
 
+func (m *MemMapFs) SymlinkIfPossible(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, err := m.lockfreeResolve(newname, false)
+	if err == nil {
+		err = m.lockfreeCheckPath(name)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if _, ok := m.lockfreeGet(name); ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrFileExists}
+	}
+	if err := m.lockfreeCheckEntry(name); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	link := mem.CreateSymlink(name, oldname)
+	m.lockfreeNewEntry(link, 0777)
+	if err := m.registerWithParent(link); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	m.notify(name, Create)
+	return nil
+}
+
+func (m *MemMapFs) ReadlinkIfPossible(name string) (string, error) {
 	m.mu.RLock()
-	f, ok := m.getData()[name]
+	defer m.mu.RUnlock()
+
+	resolved, err := m.lockfreeResolve(name, false)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	f, ok := m.lockfreeGet(resolved)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: ErrFileNotFound}
+	}
+	if !mem.IsSymlink(f) {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return mem.SymlinkTarget(f), nil
+}
+
+// linkedFileInfo describes the target of a symbolic link under the name of
+// the link.
+type linkedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *linkedFileInfo) Name() string { return fi.name }
+
+func (m *MemMapFs) Chmod(name string, mode os.FileMode) error {
+	m.mu.RLock()
+	resolved, err := m.lockfreeResolve(name, true)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		m.mu.RUnlock()
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	f, ok := m.lockfreeGet(resolved)
+	owner := ok && m.lockfreeIsOwner(f)
 	m.mu.RUnlock()
 	if !ok {
 		return &os.PathError{Op: "chmod", Path: name, Err: ErrFileNotFound}
 	}
+	if !owner {
+		return &os.PathError{Op: "chmod", Path: name, Err: os.ErrPermission}
+	}
+
+	// Directories stay directories, whatever the mode says
+	if mem.GetFileInfo(f).IsDir() {
+		mode |= os.ModeDir
+	}
 
 	m.mu.Lock()
 	mem.SetMode(f, mode)
 	m.mu.Unlock()
+	m.notify(resolved, Chmod)
 
 	return nil
 }
 
-func (m *MemMapFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	name = normalizePath(name)
+func (m *MemMapFs) Chown(name string, uid, gid int) error {
+	return m.chown("chown", name, uid, gid, true)
+}
 
+func (m *MemMapFs) Lchown(name string, uid, gid int) error {
+	return m.chown("lchown", name, uid, gid, false)
+}
+
+func (m *MemMapFs) chown(op, name string, uid, gid int, followLast bool) error {
 	m.mu.RLock()
-	f, ok := m.getData()[name]
+	resolved, err := m.lockfreeResolve(name, followLast)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		m.mu.RUnlock()
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	f, ok := m.lockfreeGet(resolved)
+	allowed := ok && m.lockfreeCanChown(f, uid, gid)
+	m.mu.RUnlock()
+	if !ok {
+		return &os.PathError{Op: op, Path: name, Err: ErrFileNotFound}
+	}
+	if !allowed {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+
+	m.mu.Lock()
+	mem.SetOwner(f, uid, gid)
+	m.mu.Unlock()
+	m.notify(resolved, Chmod)
+
+	return nil
+}
+
+func (m *MemMapFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	m.mu.RLock()
+	resolved, err := m.lockfreeResolve(name, true)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		m.mu.RUnlock()
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	f, ok := m.lockfreeGet(resolved)
+	owner := ok && m.lockfreeIsOwner(f)
 	m.mu.RUnlock()
 	if !ok {
 		return &os.PathError{Op: "chtimes", Path: name, Err: ErrFileNotFound}
 	}
+	if !owner {
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrPermission}
+	}
 
 	m.mu.Lock()
 	mem.SetModTime(f, mtime)
 	m.mu.Unlock()
+	m.notify(resolved, Chmod)
 
 	return nil
 }
 
+// Watch reports the changes made through m as they happen.
+func (m *MemMapFs) Watch(name string, recursive bool) (Watch, error) {
+	m.mu.RLock()
+	resolved, err := m.lockfreeResolve(name, true)
+	if err == nil {
+		err = m.lockfreeCheckPath(resolved)
+	}
+	if err != nil {
+		m.mu.RUnlock()
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	_, ok := m.lockfreeGet(resolved)
+	m.mu.RUnlock()
+	if !ok {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: ErrFileNotFound}
+	}
+
+	w := &memWatch{name: resolved, recursive: recursive}
+	w.eventQueue = newEventQueue(func() error {
+		m.wmu.Lock()
+		delete(m.watches, w)
+		m.wmu.Unlock()
+		return nil
+	})
+	m.wmu.Lock()
+	if m.watches == nil {
+		m.watches = make(map[*memWatch]struct{})
+	}
+	m.watches[w] = struct{}{}
+	m.wmu.Unlock()
+	return w, nil
+}
+
+// memWatch is a Watch on a MemMapFs.
+type memWatch struct {
+	*eventQueue
+	name      string
+	recursive bool
+}
+
+// notify hands an event to the watches interested in name.
+func (m *MemMapFs) notify(name string, op Op) {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	for w := range m.watches {
+		if watchCovers(w.name, name, w.recursive) {
+			w.push(Event{Name: name, Op: op})
+		}
+	}
+}
+
 func (m *MemMapFs) List() {
-	for _, x := range m.data {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, name := range appendTreeNames(nil, m.getRoot()) {
+		x, _ := m.lockfreeGet(name)
 		y := mem.FileInfo{FileData: x}
 		fmt.Println(x.Name(), y.Size())
 	}
@@ -0,0 +1,182 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero/mem"
+)
+
+// The access a permission check asks for, as in the permission bits of
+// the owner, the group and the others.
+const (
+	permRead  os.FileMode = 4
+	permWrite os.FileMode = 2
+	permExec  os.FileMode = 1
+)
+
+// memIdentity is the simulated process identity the permissions of a
+// MemMapFs are checked against.
+type memIdentity struct {
+	uid, gid int
+	umask    os.FileMode
+}
+
+// EnforcePermissions makes m check the permission bits of its files and
+// directories as the kernel would for a process running as uid and gid,
+// failing with os.ErrPermission: reading, writing and searching need the
+// read, write and execute bits, creating, removing and renaming an entry
+// need write access to its directory, and only the owner may Chmod, Chown
+// or Chtimes a file. Files and directories created afterwards belong to uid
+// and gid, their permission bits masked by umask. A uid of 0 is root and
+// passes every check.
+//
+// Permissions are not enforced by default. EnforcePermissions must be
+// called before m is used.
+func (m *MemMapFs) EnforcePermissions(uid, gid int, umask os.FileMode) {
+	m.mu.Lock()
+	m.identity = &memIdentity{uid: uid, gid: gid, umask: umask.Perm()}
+	m.mu.Unlock()
+}
+
+// lockfreeCan reports whether the identity of m has the access want, a
+// combination of permRead, permWrite and permExec, to f.
+func (m *MemMapFs) lockfreeCan(f *mem.FileData, want os.FileMode) bool {
+	id := m.identity
+	if id == nil || id.uid == 0 || want == 0 {
+		return true
+	}
+	fi := mem.GetFileInfo(f)
+	perm, owner := fi.Mode().Perm(), fi.Sys().(*mem.Stat)
+	switch {
+	case owner.Uid == id.uid:
+		perm >>= 6
+	case owner.Gid == id.gid:
+		perm >>= 3
+	}
+	return perm&want == want
+}
+
+// lockfreeIsOwner reports whether the identity of m may change the
+// metadata of f.
+func (m *MemMapFs) lockfreeIsOwner(f *mem.FileData) bool {
+	id := m.identity
+	if id == nil || id.uid == 0 {
+		return true
+	}
+	return mem.GetFileInfo(f).Sys().(*mem.Stat).Uid == id.uid
+}
+
+// lockfreeCanChown reports whether the identity of m may give f to uid and
+// gid, -1 leaving either unchanged: only root gives files away, an owner
+// may only change the group to their own.
+func (m *MemMapFs) lockfreeCanChown(f *mem.FileData, uid, gid int) bool {
+	id := m.identity
+	if id == nil || id.uid == 0 {
+		return true
+	}
+	owner := mem.GetFileInfo(f).Sys().(*mem.Stat)
+	return owner.Uid == id.uid && (uid == -1 || uid == id.uid) &&
+		(gid == -1 || gid == id.gid || gid == owner.Gid)
+}
+
+// lockfreeCheckPath checks that the directories leading to name, a resolved
+// path, can be searched. Missing elements are left to the caller.
+func (m *MemMapFs) lockfreeCheckPath(name string) error {
+	if m.identity == nil {
+		return nil
+	}
+	dir := m.getRoot()
+	for _, part := range strings.Split(filepath.Dir(name), FilePathSeparator) {
+		if part == "" {
+			continue
+		}
+		if !m.lockfreeCan(dir, permExec) {
+			return os.ErrPermission
+		}
+		var ok bool
+		if dir, ok = mem.FindInMemDir(dir, part); !ok {
+			return nil
+		}
+	}
+	if !m.lockfreeCan(dir, permExec) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// lockfreeCheckEntry checks that the entry name, a resolved path, can be
+// added to or removed from its directory.
+func (m *MemMapFs) lockfreeCheckEntry(name string) error {
+	if m.identity == nil {
+		return nil
+	}
+	if err := m.lockfreeCheckPath(name); err != nil {
+		return err
+	}
+	if parent, ok := m.lockfreeGet(filepath.Dir(name)); ok && !m.lockfreeCan(parent, permWrite|permExec) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// lockfreeCheckTree checks that the entries below f can be removed, as
+// RemoveAll does it: every directory which is not empty must be listed,
+// searched and written to.
+func (m *MemMapFs) lockfreeCheckTree(f *mem.FileData) error {
+	if m.identity == nil {
+		return nil
+	}
+	children := mem.MemDirFiles(f)
+	if len(children) > 0 && !m.lockfreeCan(f, permRead|permWrite|permExec) {
+		return os.ErrPermission
+	}
+	for _, child := range children {
+		if err := m.lockfreeCheckTree(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockfreeNewEntry gives f, a file or directory just created with the
+// permission bits perm, to the identity of m.
+func (m *MemMapFs) lockfreeNewEntry(f *mem.FileData, perm os.FileMode) {
+	mode := mem.GetFileInfo(f).Mode()&os.ModeType | perm
+	if id := m.identity; id != nil {
+		mode &^= id.umask
+		mem.SetOwner(f, id.uid, id.gid)
+	}
+	mem.SetMode(f, mode)
+}
+
+// openAccess returns the access OpenFile with flag asks for.
+func openAccess(flag int) os.FileMode {
+	var want os.FileMode
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		want = permRead
+	case os.O_WRONLY:
+		want = permWrite
+	default:
+		want = permRead | permWrite
+	}
+	if flag&os.O_TRUNC != 0 {
+		want |= permWrite
+	}
+	return want
+}
@@ -0,0 +1,55 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import "github.com/spf13/afero/mem"
+
+// MemMapSnapshot is the state of a MemMapFs at the time Snapshot was
+// called. It does not change afterwards, whatever happens to the MemMapFs,
+// and can be restored any number of times.
+type MemMapSnapshot struct {
+	root *mem.FileData
+}
+
+// Snapshot returns the current state of m, for Restore to bring it back.
+// It copies the entries of m changed since m was restored or last
+// snapshotted, in time proportional to their number, the first snapshot
+// copying them all. The content of the files is shared until written to.
+func (m *MemMapFs) Snapshot() *MemMapSnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return &MemMapSnapshot{root: mem.FreezeTree(m.getRoot())}
+}
+
+// Restore brings m back to the state of s, in constant time: the entries of
+// s are shared with m, each copied the first time m looks it up. It is not
+// reported to the watches of m, and the files open before keep working on
+// the content they had, no longer part of m.
+func (m *MemMapFs) Restore(s *MemMapSnapshot) {
+	root := mem.Thaw(s.root)
+	m.mu.Lock()
+	m.getRoot()
+	m.root = root
+	m.mu.Unlock()
+}
+
+// Clone returns an independent copy of m, as restoring a Snapshot of m in a
+// new MemMapFs does, at the cost of that snapshot. To fork a tree many
+// times, snapshot it once and restore the snapshot for each copy. The clone
+// enforces the same permissions as m but has none of its watches.
+func (m *MemMapFs) Clone() *MemMapFs {
+	c := &MemMapFs{root: mem.Thaw(m.Snapshot().root), identity: m.identity}
+	c.init.Do(func() {})
+	return c
+}
@@ -1,13 +1,18 @@
 package afero
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/spf13/afero/mem"
 )
 
 func TestNormalizePath(t *testing.T) {
@@ -23,6 +28,8 @@ func TestNormalizePath(t *testing.T) {
 		{"../", FilePathSeparator},
 		{"./..", FilePathSeparator},
 		{"./../", FilePathSeparator},
+		{"foo", FilePathSeparator + "foo"},
+		{"./foo/../bar", FilePathSeparator + "bar"},
 	}
 
 	for i, d := range data {
@@ -59,6 +66,10 @@ func TestPathErrors(t *testing.T) {
 
 	// fs.Create doesn't return an error
 
+	err = fs.Mkdir(filepath.Dir(path2), perm)
+	if err != nil {
+		t.Error(err)
+	}
 	err = fs.Mkdir(path2, perm)
 	if err != nil {
 		t.Error(err)
@@ -421,6 +432,218 @@ func TestMemFsDirMode(t *testing.T) {
 	}
 }
 
+func TestMemFsOpenFileErrors(t *testing.T) {
+	fs := NewMemMapFs()
+	WriteFile(fs, "/file", []byte("content"), 0644)
+	fs.Mkdir("/dir", 0755)
+	fs.(Linker).SymlinkIfPossible("/missing", "/dangling")
+
+	for _, test := range []struct {
+		what string
+		err  error
+		want error
+	}{
+		{"Create in a missing directory", createErr(fs, "/missing/file"), ErrFileNotFound},
+		{"Create below a file", createErr(fs, "/file/sub"), syscall.ENOTDIR},
+		{"Create over a directory", createErr(fs, "/dir"), syscall.EISDIR},
+		{"Open below a file", openErr(fs, "/file/sub", os.O_RDONLY), syscall.ENOTDIR},
+		{"O_EXCL on a file", openErr(fs, "/file", os.O_RDWR|os.O_CREATE|os.O_EXCL), ErrFileExists},
+		{"O_EXCL on a dangling link", openErr(fs, "/dangling", os.O_RDWR|os.O_CREATE|os.O_EXCL), ErrFileExists},
+		{"Directory opened for writing", openErr(fs, "/dir", os.O_WRONLY), syscall.EISDIR},
+		{"Mkdir in a missing directory", fs.Mkdir("/missing/dir", 0755), ErrFileNotFound},
+		{"MkdirAll below a file", fs.MkdirAll("/file/a/b", 0755), syscall.ENOTDIR},
+		{"MkdirAll over a file", fs.MkdirAll("/file", 0755), syscall.ENOTDIR},
+		{"Rename to a missing directory", fs.Rename("/file", "/missing/file"), ErrFileNotFound},
+	} {
+		if err, ok := test.err.(*os.PathError); !ok || err.Err != test.want {
+			t.Errorf("%s returned %v, want %v", test.what, test.err, test.want)
+		}
+	}
+	if _, err := fs.Stat("/missing"); !os.IsNotExist(err) {
+		t.Errorf("a missing parent was created: %v", err)
+	}
+	if err := fs.MkdirAll("/dir/a/b", 0755); err != nil {
+		t.Error(err)
+	}
+}
+
+func createErr(fs Fs, name string) error {
+	_, err := fs.Create(name)
+	return err
+}
+
+func openErr(fs Fs, name string, flag int) error {
+	f, err := fs.OpenFile(name, flag, 0644)
+	if err == nil {
+		f.Close()
+	}
+	return err
+}
+
+func TestMemFsEnforcePermissions(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.Mkdir("/home", 0755)
+	fs.Chown("/home", 1000, 1000)
+	fs.EnforcePermissions(1000, 1000, 022)
+	denied := func(what string, err error) {
+		t.Helper()
+		if !os.IsPermission(err) {
+			t.Errorf("%s returned %v, want a permission error", what, err)
+		}
+	}
+
+	fs.Mkdir("/home/dir", 0777)
+	if fi, err := fs.Stat("/home/dir"); err != nil || fi.Mode().Perm() != 0755 {
+		t.Fatalf("Mkdir with a umask of 022: %v, %v, want a mode of 0755", fi, err)
+	}
+	if fi, _ := fs.Stat("/home/dir"); fi.Sys().(*mem.Stat).Uid != 1000 {
+		t.Errorf("/home/dir belongs to %d, want 1000", fi.Sys().(*mem.Stat).Uid)
+	}
+
+	WriteFile(fs, "/home/dir/file", []byte("content"), 0644)
+	f, err := fs.OpenFile("/home/dir/file", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Handles opened before a Chmod keep their access
+	fs.Chmod("/home/dir/file", 0400)
+	if _, err := f.Write([]byte("x")); err != nil {
+		t.Errorf("Write through a handle opened before the Chmod: %v", err)
+	}
+	f.Close()
+	denied("OpenFile for writing of a 0400 file", openErr(fs, "/home/dir/file", os.O_WRONLY))
+	if _, err := ReadFile(fs, "/home/dir/file"); err != nil {
+		t.Errorf("ReadFile of a 0400 file: %v", err)
+	}
+
+	// The group and the others get their own bits
+	fs.Chmod("/home/dir/file", 0604)
+	fs.Chown("/home/dir/file", -1, 1000)
+	fs.EnforcePermissions(0, 0, 0)
+	fs.Chown("/home/dir/file", 2000, 1000)
+	fs.EnforcePermissions(1000, 1000, 0)
+	denied("Open by the group of a 0604 file", openErr(fs, "/home/dir/file", os.O_RDONLY))
+	fs.EnforcePermissions(3000, 3000, 0)
+	if err := openErr(fs, "/home/dir/file", os.O_RDONLY); err != nil {
+		t.Errorf("Open by the others of a 0604 file: %v", err)
+	}
+	fs.EnforcePermissions(1000, 1000, 022)
+
+	fs.Chmod("/home/dir", 0000)
+	denied("Open of a 0000 directory", openErr(fs, "/home/dir", os.O_RDONLY))
+	_, err = fs.Stat("/home/dir/file")
+	denied("Stat below a 0000 directory", err)
+	fs.Chmod("/home/dir", 0500)
+	denied("Create in a 0500 directory", createErr(fs, "/home/dir/new"))
+	denied("Mkdir in a 0500 directory", fs.Mkdir("/home/dir/sub", 0755))
+	denied("Remove from a 0500 directory", fs.Remove("/home/dir/file"))
+	denied("RemoveAll of a 0500 directory", fs.RemoveAll("/home/dir"))
+	denied("Rename from a 0500 directory", fs.Rename("/home/dir/file", "/home/file"))
+	denied("Chmod of a file of another user", fs.Chmod("/home/dir/file", 0777))
+	denied("Chown to another user", fs.Chown("/home/dir", 2000, -1))
+	denied("Chtimes of a file of another user", fs.Chtimes("/home/dir/file", time.Now(), time.Now()))
+
+	// Root passes every check
+	fs.EnforcePermissions(0, 0, 022)
+	if err := fs.RemoveAll("/home/dir"); err != nil {
+		t.Errorf("RemoveAll as root: %v", err)
+	}
+}
+
+func TestMemFsSnapshot(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.MkdirAll("/dir/sub", 0755)
+	WriteFile(fs, "/dir/a", []byte("a content"), 0644)
+	big := bytes.Repeat([]byte("x"), 1<<20)
+	WriteFile(fs, "/dir/sub/big", big, 0644)
+	f, err := fs.OpenFile("/dir/a", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	snapshot := fs.Snapshot()
+	f.WriteAt([]byte("A"), 0)
+	fs.RemoveAll("/dir/sub")
+	WriteFile(fs, "/dir/new", []byte("new"), 0644)
+
+	fs.Restore(snapshot)
+	checkContent := func(fs Fs, name, want string) {
+		t.Helper()
+		if data, err := ReadFile(fs, name); err != nil || string(data) != want {
+			t.Errorf("%s contains %.20q, %v, want %.20q", name, data, err, want)
+		}
+	}
+	checkContent(fs, "/dir/a", "a content")
+	checkContent(fs, "/dir/sub/big", string(big))
+	if _, err := fs.Stat("/dir/new"); !os.IsNotExist(err) {
+		t.Errorf("/dir/new still exists after Restore: %v", err)
+	}
+	// The snapshot is not changed by writes after Restore
+	WriteFile(fs, "/dir/a", []byte("changed"), 0644)
+	fs.Restore(snapshot)
+	checkContent(fs, "/dir/a", "a content")
+
+	// Clones are independent, in both directions
+	clone := fs.Clone()
+	cf, _ := clone.OpenFile("/dir/sub/big", os.O_WRONLY, 0)
+	cf.WriteAt([]byte("clone"), 1000)
+	cf.Close()
+	of, _ := fs.OpenFile("/dir/sub/big", os.O_WRONLY, 0)
+	of.WriteAt([]byte("original"), 2000)
+	of.Close()
+	checkContent(fs, "/dir/sub/big", string(big[:2000])+"original"+string(big[2008:]))
+	checkContent(clone, "/dir/sub/big", string(big[:1000])+"clone"+string(big[1005:]))
+	clone.Remove("/dir/a")
+	checkContent(fs, "/dir/a", "a content")
+
+	// Subtests can clone a fixture concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clone := fs.Clone()
+			WriteFile(clone, "/dir/a", []byte(fmt.Sprint(i)), 0644)
+			checkContent(clone, "/dir/a", fmt.Sprint(i))
+		}(i)
+	}
+	wg.Wait()
+	checkContent(fs, "/dir/a", "a content")
+}
+
+func BenchmarkMemFsClone(b *testing.B) {
+	fs := &MemMapFs{}
+	for i := 0; i < 100; i++ {
+		dir := fmt.Sprintf("/dir%d", i)
+		fs.Mkdir(dir, 0755)
+		for j := 0; j < 10; j++ {
+			WriteFile(fs, fmt.Sprintf("%s/file%d", dir, j), make([]byte, 100<<10), 0644)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fs.Clone()
+	}
+}
+
+func BenchmarkMemFsRestore(b *testing.B) {
+	fs := &MemMapFs{}
+	for i := 0; i < 100; i++ {
+		dir := fmt.Sprintf("/dir%d", i)
+		fs.Mkdir(dir, 0755)
+		for j := 0; j < 10; j++ {
+			WriteFile(fs, fmt.Sprintf("%s/file%d", dir, j), make([]byte, 100<<10), 0644)
+		}
+	}
+	snapshot := fs.Snapshot()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fs.Restore(snapshot)
+		WriteFile(fs, "/dir0/file0", []byte("changed"), 0644)
+	}
+}
+
 func TestMemFsUnexpectedEOF(t *testing.T) {
 	t.Parallel()
 
@@ -442,10 +665,147 @@ func TestMemFsUnexpectedEOF(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// As with an *os.File, there is nothing to read past the end
 	buff := make([]byte, 256)
 	_, err = io.ReadAtLeast(f, buff, 256)
 
-	if err != io.ErrUnexpectedEOF {
-		t.Fatal("Expected ErrUnexpectedEOF")
+	if err != io.EOF {
+		t.Fatal("Expected EOF")
+	}
+}
+
+func TestMemFsRemoveAllSiblingPrefix(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	fs.MkdirAll("/foo/sub", 0755)
+	WriteFile(fs, "/foo/sub/file", []byte("x"), 0644)
+	WriteFile(fs, "/foobar", []byte("x"), 0644)
+	fs.MkdirAll("/foo.d", 0755)
+
+	if err := fs.RemoveAll("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/foo", "/foo/sub", "/foo/sub/file"} {
+		if _, err := fs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s not removed: %v", name, err)
+		}
+	}
+	for _, name := range []string{"/foobar", "/foo.d"} {
+		if _, err := fs.Stat(name); err != nil {
+			t.Errorf("%s removed along with /foo: %v", name, err)
+		}
+	}
+
+	if err := fs.RemoveAll("/"); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := ReadDir(fs, "/"); err != nil || len(names) != 0 {
+		t.Errorf("ReadDir of / after RemoveAll: %d entries, %v", len(names), err)
+	}
+}
+
+func TestMemFsRenameDir(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	fs.MkdirAll("/src/sub", 0755)
+	WriteFile(fs, "/src/sub/file", []byte("content"), 0644)
+
+	if err := fs.Rename("/src", "/dst"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadFile(fs, "/dst/sub/file")
+	if err != nil || string(data) != "content" {
+		t.Errorf("ReadFile of the moved file: %q, %v", data, err)
+	}
+	f, err := fs.Open("/dst/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fis, err := f.Readdir(-1)
+	f.Close()
+	if err != nil || len(fis) != 1 || fis[0].Name() != "file" {
+		t.Errorf("Readdir of the moved directory: %v, %v", fis, err)
+	}
+	if f, err := fs.Open("/dst/sub/file"); err != nil || f.Name() != filepath.FromSlash("/dst/sub/file") {
+		t.Errorf("the moved file is named %q, %v", f.Name(), err)
+	}
+	if _, err := fs.Stat("/src/sub/file"); !os.IsNotExist(err) {
+		t.Errorf("/src/sub/file still there: %v", err)
+	}
+
+	if err := fs.Rename("/dst", "/dst/sub/inside"); err == nil {
+		t.Error("renamed a directory into itself")
+	}
+}
+
+func TestMemFsRenameOverExisting(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	fs.MkdirAll("/dir/sub", 0755)
+	fs.MkdirAll("/full/sub", 0755)
+	fs.MkdirAll("/empty", 0755)
+	WriteFile(fs, "/file", []byte("file"), 0644)
+
+	for _, test := range []struct {
+		oldname, newname string
+		want             error
+	}{
+		{"/dir", "/full", syscall.ENOTEMPTY},
+		{"/file", "/empty", syscall.EISDIR},
+		{"/dir", "/file", syscall.ENOTDIR},
+	} {
+		err := fs.Rename(test.oldname, test.newname)
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != test.want {
+			t.Errorf("Rename(%s, %s) = %v, want %v", test.oldname, test.newname, err, test.want)
+		}
+	}
+	for _, name := range []string{"/dir/sub", "/full/sub", "/empty", "/file"} {
+		if _, err := fs.Stat(name); err != nil {
+			t.Errorf("%s lost by a failed Rename: %v", name, err)
+		}
+	}
+
+	if err := fs.Rename("/dir", "/empty"); err != nil {
+		t.Fatalf("Rename over an empty directory: %v", err)
+	}
+	if _, err := fs.Stat("/empty/sub"); err != nil {
+		t.Errorf("the moved directory lost its entries: %v", err)
+	}
+}
+
+func TestMemFsPathErrorNames(t *testing.T) {
+	t.Parallel()
+
+	fs := &MemMapFs{}
+	fs.MkdirAll("/dir", 0755)
+	fs.SymlinkIfPossible("/dir", "/link")
+	name := filepath.FromSlash("/link/missing")
+
+	for op, err := range map[string]error{
+		"Remove": fs.Remove(name),
+		"Chmod":  fs.Chmod(name, 0644),
+		"Rename": fs.Rename(name, "/other"),
+	} {
+		if perr, ok := err.(*os.PathError); !ok || perr.Path != name {
+			t.Errorf("%s returned %v, want a PathError for %s", op, err, name)
+		}
+	}
+	if _, err := fs.Open(name); err == nil || err.(*os.PathError).Path != name {
+		t.Errorf("Open returned %v, want a PathError for %s", err, name)
+	}
+}
+
+func BenchmarkMemFsRemoveAllSmallTree(b *testing.B) {
+	fs := NewMemMapFs()
+	for i := 0; i < 100000; i++ {
+		WriteFile(fs, fmt.Sprintf("/big/%d/%d", i%100, i), nil, 0644)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		WriteFile(fs, "/small/file", nil, 0644)
+		fs.RemoveAll("/small")
 	}
 }
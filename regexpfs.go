@@ -37,7 +37,7 @@ func (r *RegexpFs) matchesName(name string) error {
 
 func (r *RegexpFs) dirOrMatches(name string) error {
 	dir, err := IsDir(r.source, name)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	if dir {
@@ -60,6 +60,26 @@ func (r *RegexpFs) Chmod(name string, mode os.FileMode) error {
 	return r.source.Chmod(name, mode)
 }
 
+func (r *RegexpFs) Chown(name string, uid, gid int) error {
+	if err := r.dirOrMatches(name); err != nil {
+		return err
+	}
+	if chowner, ok := r.source.(Chowner); ok {
+		return chowner.Chown(name, uid, gid)
+	}
+	return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+}
+
+func (r *RegexpFs) Lchown(name string, uid, gid int) error {
+	if err := r.dirOrMatches(name); err != nil {
+		return err
+	}
+	if chowner, ok := r.source.(Chowner); ok {
+		return chowner.Lchown(name, uid, gid)
+	}
+	return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+}
+
 func (r *RegexpFs) Name() string {
 	return "RegexpFs"
 }
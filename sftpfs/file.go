@@ -14,12 +14,50 @@
 package sftpfs
 
 import (
-	"github.com/pkg/sftp"
+	"io"
 	"os"
+	"syscall"
+
+	"github.com/pkg/sftp"
 )
 
+var _ io.ReaderFrom = (*File)(nil)
+var _ io.WriterTo = (*File)(nil)
+
 type File struct {
-	fd *sftp.File
+	conn *conn
+	fd   *sftp.File
+	flag int
+
+	// The entries of the directory, listed on the first call to Readdir
+	// and returned from dirAt on
+	dir   []os.FileInfo
+	dirAt int
+}
+
+func newFile(c *conn, fd *sftp.File, flag int) *File {
+	return &File{conn: c, fd: fd, flag: flag}
+}
+
+// checkAccess fails as os.File does if f was not opened for reading, or
+// for writing if write is set. The servers do not always check it.
+func (f *File) checkAccess(op string, write bool) error {
+	access := f.flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	if write && access == os.O_RDONLY || !write && access == os.O_WRONLY {
+		return &os.PathError{Op: op, Path: f.Name(), Err: syscall.EBADF}
+	}
+	return nil
+}
+
+// check returns err, returned by the client for the operation op, as a
+// *ConnectionLostError if the connection of f was lost.
+func (f *File) check(op string, err error) error {
+	if f.conn.isLost(err) {
+		// the next requests are sent on a new connection
+		f.conn.drop()
+		return &ConnectionLostError{Op: op, Path: f.Name(), Err: unwrap(err)}
+	}
+	return err
 }
 
 func FileOpen(s *sftp.Client, name string) (*File, error) {
@@ -27,7 +65,7 @@ func FileOpen(s *sftp.Client, name string) (*File, error) {
 	if err != nil {
 		return &File{}, err
 	}
-	return &File{fd: fd}, nil
+	return newFile(&conn{Client: s}, fd, os.O_RDONLY), nil
 }
 
 func FileCreate(s *sftp.Client, name string) (*File, error) {
@@ -35,11 +73,11 @@ func FileCreate(s *sftp.Client, name string) (*File, error) {
 	if err != nil {
 		return &File{}, err
 	}
-	return &File{fd: fd}, nil
+	return newFile(&conn{Client: s}, fd, os.O_RDWR|os.O_CREATE|os.O_TRUNC), nil
 }
 
 func (f *File) Close() error {
-	return f.fd.Close()
+	return f.check("close", f.fd.Close())
 }
 
 func (f *File) Name() string {
@@ -47,7 +85,8 @@ func (f *File) Name() string {
 }
 
 func (f *File) Stat() (os.FileInfo, error) {
-	return f.fd.Stat()
+	fi, err := f.fd.Stat()
+	return fi, f.check("stat", err)
 }
 
 func (f *File) Sync() error {
@@ -55,41 +94,161 @@ func (f *File) Sync() error {
 }
 
 func (f *File) Truncate(size int64) error {
-	return f.fd.Truncate(size)
+	if err := f.checkAccess("truncate", true); err != nil {
+		return err
+	}
+	return f.check("truncate", f.fd.Truncate(size))
 }
 
 func (f *File) Read(b []byte) (n int, err error) {
-	return f.fd.Read(b)
+	if err := f.checkAccess("read", false); err != nil {
+		return 0, err
+	}
+	n, err = f.fd.Read(b)
+	return n, f.check("read", err)
 }
 
-// TODO
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
-	return 0, nil
+	if err := f.checkAccess("read", false); err != nil {
+		return 0, err
+	}
+	n, err = f.fd.ReadAt(b, off)
+	return n, f.check("read", err)
 }
 
-// TODO
+// Readdir lists the directory on the first call, and returns its entries
+// count at most at a time if count is positive, as os.File.Readdir does.
 func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
-	return nil, nil
+	if f.dir == nil {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, &os.PathError{Op: "readdir", Path: f.Name(), Err: syscall.ENOTDIR}
+		}
+		infos, err := f.conn.ReadDir(f.Name())
+		if err := f.check("readdir", err); err != nil {
+			if _, ok := err.(*ConnectionLostError); ok {
+				return nil, err
+			}
+			return nil, &os.PathError{Op: "readdir", Path: f.Name(), Err: unwrap(err)}
+		}
+		f.dir = append([]os.FileInfo{}, infos...)
+	}
+	infos := f.dir[f.dirAt:]
+	if count > 0 {
+		if len(infos) == 0 {
+			return nil, io.EOF
+		}
+		if len(infos) > count {
+			infos = infos[:count]
+		}
+	}
+	f.dirAt += len(infos)
+	return infos, nil
 }
 
-// TODO
 func (f *File) Readdirnames(n int) (names []string, err error) {
-	return nil, nil
+	infos, err := f.Readdir(n)
+	names = make([]string, len(infos))
+	for i, fi := range infos {
+		names[i] = fi.Name()
+	}
+	return names, err
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
-	return f.fd.Seek(offset, whence)
+	off, err := f.fd.Seek(offset, whence)
+	return off, f.check("seek", err)
 }
 
+// Write writes at the end of the file if it was opened with O_APPEND: the
+// servers leave the offsets of the writes to the clients.
 func (f *File) Write(b []byte) (n int, err error) {
-	return f.fd.Write(b)
+	if err := f.checkAccess("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+	n, err = f.fd.Write(b)
+	return n, f.check("write", err)
 }
 
-// TODO
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
-	return 0, nil
+	if err := f.checkAccess("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.Name(), Err: syscall.EINVAL}
+	}
+	n, err = f.fd.WriteAt(b, off)
+	return n, f.check("write", err)
+}
+
+// ReadFrom writes the content of r to f, with as many write requests in
+// flight as the client allows per file. As the requests complete in any
+// order, the file may have been written past the first failed write.
+func (f *File) ReadFrom(r io.Reader) (n int64, err error) {
+	if err := f.checkAccess("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+	src := &errReader{r: r}
+	n, err = f.fd.ReadFromWithConcurrency(src, 0)
+	if err != nil && err == src.err {
+		return n, err
+	}
+	return n, f.check("write", err)
+}
+
+// WriteTo writes the content of f to w, with as many read requests in
+// flight as the client allows per file.
+func (f *File) WriteTo(w io.Writer) (n int64, err error) {
+	if err := f.checkAccess("read", false); err != nil {
+		return 0, err
+	}
+	dst := &errWriter{w: w}
+	n, err = f.fd.WriteTo(dst)
+	if err != nil && err == dst.err {
+		return n, err
+	}
+	return n, f.check("read", err)
+}
+
+// errReader keeps the last error of r, telling it apart from those of the
+// connection.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.err = err
+	return n, err
+}
+
+// errWriter keeps the last error of w, telling it apart from those of the
+// connection.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.err = err
+	return n, err
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
-	return f.fd.Write([]byte(s))
+	return f.Write([]byte(s))
 }
@@ -0,0 +1,174 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sftpfs
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/sftp"
+)
+
+// DialFunc returns a client over a new SFTP session.
+type DialFunc func() (*sftp.Client, error)
+
+// ConnectionLostError is the error of the operations on a File whose
+// connection was lost. The handle of the file was lost with it: the file
+// has to be opened again, on a new connection.
+type ConnectionLostError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *ConnectionLostError) Error() string {
+	return e.Op + " " + e.Path + ": connection lost: " + e.Err.Error()
+}
+
+// conn is a client and the state of its connection.
+type conn struct {
+	*sftp.Client
+	lost chan struct{} // closed once the connection is lost, if watched
+}
+
+// watch returns the conn of client, watching its connection.
+func watch(client *sftp.Client) *conn {
+	c := &conn{Client: client, lost: make(chan struct{})}
+	go func() {
+		client.Wait()
+		close(c.lost)
+	}()
+	return c
+}
+
+// isLost reports whether err, returned by the client, is due to the loss of
+// the connection.
+func (c *conn) isLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	select {
+	case <-c.lost:
+		return true
+	default:
+	}
+	// The client wraps the errors of the connection
+	for err = unwrap(err); err != nil; {
+		switch err.(type) {
+		case net.Error:
+			return true
+		case *sftp.StatusError:
+			return false
+		}
+		if err == sftp.ErrSSHFxConnectionLost || err == sftp.ErrSSHFxNoConnection ||
+			err == io.ErrClosedPipe || err == io.ErrUnexpectedEOF {
+			return true
+		}
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
+
+// drop closes c, whose connection was lost, and waits for the loss to be
+// seen by the pool. The client of a conn not watched, that of FileOpen or
+// FileCreate, belongs to the caller and is left alone.
+func (c *conn) drop() {
+	if c.lost == nil {
+		return
+	}
+	c.Close()
+	<-c.lost
+}
+
+// pool is the set of connections used by a Fs, in turn. A lost connection
+// is replaced when it is next used, if the pool can dial.
+type pool struct {
+	dial  DialFunc
+	slots []slot
+	next  uint32
+}
+
+type slot struct {
+	mu   sync.Mutex
+	conn *conn
+}
+
+func newPool(dial DialFunc, size int) *pool {
+	if size < 1 {
+		size = 1
+	}
+	return &pool{dial: dial, slots: make([]slot, size)}
+}
+
+// slot returns the next slot of the pool.
+func (p *pool) slot() *slot {
+	return &p.slots[int(atomic.AddUint32(&p.next, 1)%uint32(len(p.slots)))]
+}
+
+// get returns the connection of s, dialing it if it has never been or was
+// lost.
+func (p *pool) get(s *slot) (*conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		select {
+		case <-s.conn.lost:
+			if p.dial == nil {
+				return nil, sftp.ErrSSHFxConnectionLost
+			}
+			s.conn.Close()
+			s.conn = nil
+		default:
+			return s.conn, nil
+		}
+	}
+	client, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	s.conn = watch(client)
+	return s.conn, nil
+}
+
+// lost closes c if err tells it was lost, so that it is replaced, and
+// reports whether the request can be sent again on a new connection.
+func (p *pool) lost(c *conn, err error) bool {
+	if !c.isLost(err) {
+		return false
+	}
+	c.drop()
+	return p.dial != nil
+}
+
+// close closes the connections of the pool.
+func (p *pool) close() error {
+	var err error
+	for i := range p.slots {
+		s := &p.slots[i]
+		s.mu.Lock()
+		if s.conn != nil {
+			if err1 := s.conn.Close(); err == nil {
+				err = err1
+			}
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
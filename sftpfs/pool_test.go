@@ -0,0 +1,234 @@
+package sftpfs
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+)
+
+// dialer dials clients of RequestServers serving fs over net.Pipe, and
+// drops their connections on demand.
+type dialer struct {
+	t  *testing.T
+	fs afero.Fs
+
+	mu    sync.Mutex
+	dials int
+	conns []net.Conn
+}
+
+func newDialer(t *testing.T) *dialer {
+	d := &dialer{t: t, fs: afero.NewMemMapFs()}
+	t.Cleanup(d.drop)
+	return d
+}
+
+func (d *dialer) dial() (*sftp.Client, error) {
+	c, s := net.Pipe()
+	server := sftp.NewRequestServer(s, NewHandlers(d.fs))
+	go server.Serve()
+	client, err := sftp.NewClientPipe(c, c)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.dials++
+	d.conns = append(d.conns, s)
+	d.mu.Unlock()
+	return client, nil
+}
+
+// drop closes the server side of the connections dialed so far.
+func (d *dialer) drop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range d.conns {
+		s.Close()
+	}
+	d.conns = nil
+}
+
+func (d *dialer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
+
+func TestPoolReconnect(t *testing.T) {
+	d := newDialer(t)
+	fs := NewPool(d.dial, 1)
+	defer fs.(*Fs).Close()
+
+	if err := afero.WriteFile(fs, "/file", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.drop()
+	if data, err := afero.ReadFile(fs, "/file"); err != nil || string(data) != "content" {
+		t.Errorf("/file contains %q, %v after the connection was lost", data, err)
+	}
+	if fi, err := fs.Stat("/file"); err != nil || fi.Size() != 7 {
+		t.Errorf("Stat = %v, %v", fi, err)
+	}
+	if n := d.count(); n != 2 {
+		t.Errorf("dialed %d times, want 2", n)
+	}
+}
+
+func TestPoolLostFile(t *testing.T) {
+	d := newDialer(t)
+	fs := NewPool(d.dial, 1)
+	defer fs.(*Fs).Close()
+
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.drop()
+	_, err = f.Write([]byte("content"))
+	if lerr, ok := err.(*ConnectionLostError); !ok || lerr.Op != "write" || lerr.Path != "/file" {
+		t.Errorf("Write on a lost connection returned %v, want a *ConnectionLostError", err)
+	}
+	if _, err := f.Stat(); err == nil {
+		t.Error("Stat on a lost connection succeeded")
+	} else if _, ok := err.(*ConnectionLostError); !ok {
+		t.Errorf("Stat on a lost connection returned %v, want a *ConnectionLostError", err)
+	}
+	f.Close()
+
+	if err := afero.WriteFile(fs, "/file", []byte("again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := afero.ReadFile(fs, "/file"); err != nil || string(data) != "again" {
+		t.Errorf("/file contains %q, %v", data, err)
+	}
+}
+
+func TestPoolParallel(t *testing.T) {
+	const size = 4
+	d := newDialer(t)
+	fs := NewPool(d.dial, size)
+	defer fs.(*Fs).Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("/file%d", i)
+			content := fmt.Sprintf("content %d", i)
+			if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+				errs <- err
+				return
+			}
+			if i == cap(errs)/2 {
+				d.drop()
+			}
+			data, err := afero.ReadFile(fs, name)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(data) != content {
+				errs <- fmt.Errorf("%s contains %q, want %q", name, data, content)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		// The files open or being created when the connections were
+		// dropped are lost with them
+		if _, ok := err.(*ConnectionLostError); !ok && !new(conn).isLost(err) {
+			t.Error(err)
+		}
+	}
+	if n := d.count(); n < size || n > 2*size {
+		t.Errorf("dialed %d times, want %d to %d", n, size, 2*size)
+	}
+}
+
+func TestNewLost(t *testing.T) {
+	d := newDialer(t)
+	client, err := d.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := New(client)
+	if err := afero.WriteFile(fs, "/file", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.drop()
+	if _, err := fs.Stat("/file"); err == nil || os.IsNotExist(err) {
+		t.Errorf("Stat on a lost connection returned %v, want a failure", err)
+	}
+	if n := d.count(); n != 1 {
+		t.Errorf("dialed %d times, want 1", n)
+	}
+}
+
+// dropFs drops the connections of a dialer once it made a directory.
+type dropFs struct {
+	afero.Fs
+	d *dialer
+}
+
+func (fs dropFs) Mkdir(name string, perm os.FileMode) error {
+	err := fs.Fs.Mkdir(name, perm)
+	fs.d.drop()
+	return err
+}
+
+func TestPoolNoRetry(t *testing.T) {
+	d := newDialer(t)
+	d.fs = dropFs{d.fs, d}
+	fs := NewPool(d.dial, 1)
+	defer fs.(*Fs).Close()
+
+	// Sent again, the request would fail as the directory exists
+	err := fs.Mkdir("/dir", 0755)
+	if err == nil || os.IsExist(err) {
+		t.Errorf("Mkdir on a lost connection returned %v, want the connection error", err)
+	}
+	if fi, err := fs.Stat("/dir"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat = %v, %v, want the directory made", fi, err)
+	}
+	if n := d.count(); n != 2 {
+		t.Errorf("dialed %d times, want 2", n)
+	}
+}
+
+func TestFileOpenLost(t *testing.T) {
+	d := newDialer(t)
+	afero.WriteFile(d.fs, "/file", []byte("content"), 0644)
+	client, err := d.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	f, err := FileOpen(client, "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.drop()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Read(make([]byte, 4))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if _, ok := err.(*ConnectionLostError); !ok {
+			t.Errorf("Read on a lost connection returned %v, want a *ConnectionLostError", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read on a lost connection did not return")
+	}
+}
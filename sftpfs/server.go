@@ -0,0 +1,336 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sftpfs
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"syscall"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/mem"
+)
+
+var _ sftp.OpenFileWriter = (*handler)(nil)
+var _ sftp.PosixRenameFileCmder = (*handler)(nil)
+var _ sftp.LstatFileLister = (*handler)(nil)
+var _ sftp.ReadlinkFileLister = (*handler)(nil)
+var _ sftp.FileInfoUidGid = ownerInfo{}
+
+// NewHandlers returns the handlers of a sftp.RequestServer serving the
+// files of fs, the counterpart of the Fs:
+//
+//	server := sftp.NewRequestServer(channel, sftpfs.NewHandlers(fs))
+//	err := server.Serve()
+//
+// The paths of the requests are absolute in fs: a BasePathFs confines the
+// clients to a directory. The errors of fs are reported with the SFTP status
+// codes for missing files, denied permissions and unsupported operations,
+// and as failures otherwise. As the SFTP protocol requires, a directory is
+// only removed if it is empty and Rename does not replace an existing file,
+// while the posix-rename@openssh.com extension does.
+func NewHandlers(fs afero.Fs) sftp.Handlers {
+	h := &handler{fs: fs}
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+type handler struct {
+	fs afero.Fs
+}
+
+func (h *handler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	f, err := h.fs.OpenFile(r.Filepath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return f, nil
+}
+
+func (h *handler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return h.OpenFile(r)
+}
+
+func (h *handler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	pflags := r.Pflags()
+	flag := os.O_WRONLY
+	if pflags.Read {
+		flag = os.O_RDWR
+	}
+	// O_APPEND is left out: the clients send the offsets of their writes,
+	// the end of the file for an append
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+	f, err := h.fs.OpenFile(r.Filepath, flag, openPerm(r.Attrs))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return f, nil
+}
+
+// openPerm returns the permissions in the attributes of an open request,
+// 0666 if there are none. The flags of the request are those of the open,
+// so the attributes are decoded here: the permissions follow the flags, the
+// size if set and the owner if set.
+func openPerm(attrs []byte) os.FileMode {
+	if len(attrs) < 4 {
+		return 0666
+	}
+	flags := binary.BigEndian.Uint32(attrs)
+	attrs = attrs[4:]
+	if flags&0x4 == 0 {
+		return 0666
+	}
+	off := 0
+	if flags&0x1 != 0 {
+		off += 8
+	}
+	if flags&0x2 != 0 {
+		off += 8
+	}
+	if len(attrs) < off+4 {
+		return 0666
+	}
+	return os.FileMode(binary.BigEndian.Uint32(attrs[off:])).Perm()
+}
+
+func (h *handler) Filecmd(r *sftp.Request) error {
+	var err error
+	switch r.Method {
+	case "Setstat":
+		err = h.setstat(r)
+	case "Rename":
+		if _, err := lstat(h.fs, r.Target); err == nil {
+			return statusError(&os.LinkError{Op: "rename", Old: r.Filepath, New: r.Target, Err: os.ErrExist})
+		}
+		err = h.fs.Rename(r.Filepath, r.Target)
+	case "Rmdir":
+		err = h.rmdir(r.Filepath)
+	case "Remove":
+		var fi os.FileInfo
+		if fi, err = lstat(h.fs, r.Filepath); err == nil {
+			if fi.IsDir() {
+				return statusError(&os.PathError{Op: "remove", Path: r.Filepath, Err: syscall.EISDIR})
+			}
+			err = h.fs.Remove(r.Filepath)
+		}
+	case "Mkdir":
+		// The attributes of the request are not passed on
+		err = h.fs.Mkdir(r.Filepath, 0755)
+	case "Symlink":
+		// Filepath is the target of the link, Target the link
+		linker, ok := h.fs.(afero.Linker)
+		if !ok {
+			return sftp.ErrSSHFxOpUnsupported
+		}
+		err = linker.SymlinkIfPossible(r.Filepath, r.Target)
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	return statusError(err)
+}
+
+// PosixRename renames the file, replacing the target if it exists.
+func (h *handler) PosixRename(r *sftp.Request) error {
+	return statusError(h.fs.Rename(r.Filepath, r.Target))
+}
+
+func (h *handler) setstat(r *sftp.Request) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	if flags.Size {
+		f, err := h.fs.OpenFile(r.Filepath, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		err = f.Truncate(int64(attrs.Size))
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := h.fs.Chmod(r.Filepath, attrs.FileMode()); err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		chowner, ok := h.fs.(afero.Chowner)
+		if !ok {
+			return sftp.ErrSSHFxOpUnsupported
+		}
+		if err := chowner.Chown(r.Filepath, ownerID(attrs.UID), ownerID(attrs.GID)); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		if err := h.fs.Chtimes(r.Filepath, attrs.AccessTime(), attrs.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownerID returns an id of a setstat request for Chown: the protocol has no
+// -1 to leave an id unchanged, the id of -1 cast to an uint32 stands for it
+// as with chown(2).
+func ownerID(id uint32) int {
+	if id == math.MaxUint32 {
+		return -1
+	}
+	return int(id)
+}
+
+// rmdir removes the directory name if it is empty.
+func (h *handler) rmdir(name string) error {
+	fi, err := lstat(h.fs, name)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "rmdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(1)
+	f.Close()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if len(names) > 0 {
+		return &os.PathError{Op: "rmdir", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	return h.fs.Remove(name)
+}
+
+func (h *handler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		f, err := h.fs.Open(r.Filepath)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		defer f.Close()
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		return newListerAt(infos...), nil
+	case "Stat":
+		fi, err := h.fs.Stat(r.Filepath)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		return newListerAt(fi), nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *handler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	fi, err := lstat(h.fs, r.Filepath)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return newListerAt(fi), nil
+}
+
+func (h *handler) Readlink(name string) (string, error) {
+	reader, ok := h.fs.(afero.LinkReader)
+	if !ok {
+		return "", sftp.ErrSSHFxOpUnsupported
+	}
+	target, err := reader.ReadlinkIfPossible(name)
+	return target, statusError(err)
+}
+
+// lstat returns the FileInfo of name, not following a last symbolic link
+// if fs can tell.
+func lstat(fs afero.Fs, name string) (os.FileInfo, error) {
+	if lstater, ok := fs.(afero.Lstater); ok {
+		fi, _, err := lstater.LstatIfPossible(name)
+		return fi, err
+	}
+	return fs.Stat(name)
+}
+
+// statusError returns the SFTP status error matching err: the protocol
+// only tells missing files, denied permissions and unsupported operations
+// apart, the other errors are failures described by their message.
+func statusError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case os.IsNotExist(err):
+		return sftp.ErrSSHFxNoSuchFile
+	case os.IsPermission(err):
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	cause := unwrap(err)
+	if lerr, ok := err.(*os.LinkError); ok {
+		cause = lerr.Err
+	}
+	switch cause {
+	case afero.ErrNoSymlink, afero.ErrNoReadlink, afero.ErrNoChown:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	return err
+}
+
+// listerAt lists the FileInfos of a directory, or of a single file.
+type listerAt []os.FileInfo
+
+// newListerAt returns the listerAt of infos, reporting the owners of the
+// files of a MemMapFs.
+func newListerAt(infos ...os.FileInfo) listerAt {
+	for i, fi := range infos {
+		if st, ok := fi.Sys().(*mem.Stat); ok {
+			infos[i] = ownerInfo{fi, st}
+		}
+	}
+	return infos
+}
+
+// ownerInfo is the FileInfo of a file of a MemMapFs, reporting its owner
+// to the sftp package through sftp.FileInfoUidGid, as it cannot find it in
+// a *mem.Stat.
+type ownerInfo struct {
+	os.FileInfo
+	st *mem.Stat
+}
+
+func (fi ownerInfo) Uid() uint32 { return uint32(fi.st.Uid) }
+func (fi ownerInfo) Gid() uint32 { return uint32(fi.st.Gid) }
+
+func (l listerAt) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,156 @@
+package sftpfs
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+	"github.com/spf13/afero/mem"
+)
+
+// serve returns a Fs over a client of a RequestServer serving fs, connected
+// over net.Pipe.
+func serve(t *testing.T, fs afero.Fs) afero.Fs {
+	return New(serveClient(t, fs))
+}
+
+// serveClient returns the client of serve.
+func serveClient(t *testing.T, fs afero.Fs) *sftp.Client {
+	c, s := net.Pipe()
+	server := sftp.NewRequestServer(s, NewHandlers(fs))
+	go server.Serve()
+	client, err := sftp.NewClientPipe(c, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func TestServeMemMapFs(t *testing.T) {
+	aferotest.Suite{NewFs: func(t *testing.T) afero.Fs {
+		return serve(t, afero.NewMemMapFs())
+	}}.Run(t)
+}
+
+func TestServeOsFs(t *testing.T) {
+	aferotest.Suite{NewFs: func(t *testing.T) afero.Fs {
+		dir, err := afero.TempDir(afero.NewOsFs(), "", "sftpfs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		return serve(t, afero.NewBasePathFs(afero.NewOsFs(), dir))
+	}}.Run(t)
+}
+
+func TestServeReadOnlyFs(t *testing.T) {
+	base := afero.NewMemMapFs()
+	afero.WriteFile(base, "/file", []byte("content"), 0644)
+	fs := serve(t, afero.NewReadOnlyFs(base))
+
+	if data, err := afero.ReadFile(fs, "/file"); err != nil || string(data) != "content" {
+		t.Errorf("/file contains %q, %v", data, err)
+	}
+	if err := afero.WriteFile(fs, "/file", []byte("x"), 0644); !os.IsPermission(err) {
+		t.Errorf("writing a read-only file returned %v, want a permission error", err)
+	}
+	if err := fs.Mkdir("/dir", 0755); !os.IsPermission(err) {
+		t.Errorf("Mkdir returned %v, want a permission error", err)
+	}
+	if _, err := fs.Stat("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a missing file returned %v, want a not exist error", err)
+	}
+}
+
+func TestServeBasePathFs(t *testing.T) {
+	base := afero.NewMemMapFs()
+	base.MkdirAll("/srv/jail", 0755)
+	afero.WriteFile(base, "/secret", []byte("secret"), 0644)
+	fs := serve(t, afero.NewBasePathFs(base, "/srv/jail"))
+
+	if err := afero.WriteFile(fs, "/../../upload", []byte("upload"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := afero.ReadFile(base, "/srv/jail/upload"); err != nil || string(data) != "upload" {
+		t.Errorf("/srv/jail/upload contains %q, %v", data, err)
+	}
+	if _, err := afero.ReadFile(fs, "/../secret"); !os.IsNotExist(err) {
+		t.Errorf("reading out of the base path returned %v, want a not exist error", err)
+	}
+}
+
+func TestServeSymlinks(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	fs := serve(t, mem).(*Fs)
+	afero.WriteFile(fs, "/file", []byte("content"), 0644)
+	if err := fs.SymlinkIfPossible("/file", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := fs.ReadlinkIfPossible("/link"); err != nil || target != "/file" {
+		t.Errorf("Readlink = %q, %v", target, err)
+	}
+	if fi, _, err := fs.LstatIfPossible("/link"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat = %v, %v, want a symbolic link", fi, err)
+	}
+	if data, err := afero.ReadFile(fs, "/link"); err != nil || string(data) != "content" {
+		t.Errorf("/link contains %q, %v", data, err)
+	}
+}
+
+func TestOpenPerm(t *testing.T) {
+	for _, c := range []struct {
+		attrs []byte
+		want  os.FileMode
+	}{
+		{nil, 0666},
+		{[]byte{0, 0, 0, 0}, 0666},
+		{[]byte{0, 0, 0, 4, 0, 0, 0x81, 0xa4}, 0644},
+		{[]byte{0, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 9, 0, 0, 0x81, 0xc0}, 0700},
+		{[]byte{0, 0, 0, 4, 0}, 0666},
+	} {
+		if perm := openPerm(c.attrs); perm != c.want {
+			t.Errorf("openPerm(%v) = %v, want %v", c.attrs, perm, c.want)
+		}
+	}
+}
+
+func TestServeChown(t *testing.T) {
+	base := afero.NewMemMapFs()
+	afero.WriteFile(base, "/file", []byte("content"), 0644)
+	base.(afero.Chowner).Chown("/file", 1000, 100)
+	client := serveClient(t, base)
+	fs := New(client).(*Fs)
+	owner := func() (int, int) {
+		fi, err := base.Stat("/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*mem.Stat)
+		return st.Uid, st.Gid
+	}
+
+	if err := fs.Chown("/file", -1, 200); err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := owner(); uid != 1000 || gid != 200 {
+		t.Errorf("Chown(-1, 200) set %d:%d, want 1000:200", uid, gid)
+	}
+	if fi, err := fs.Stat("/file"); err != nil || fi.Sys().(*sftp.FileStat).UID != 1000 {
+		t.Errorf("Stat returned %v, %v, want the owner 1000", fi, err)
+	}
+
+	// A client sending -1 as is leaves the id unchanged too
+	if err := client.Chown("/file", 2000, -1); err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := owner(); uid != 2000 || gid != 200 {
+		t.Errorf("setstat of 2000:0xffffffff set %d:%d, want 2000:200", uid, gid)
+	}
+}
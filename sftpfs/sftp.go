@@ -15,36 +15,111 @@ package sftpfs
 
 import (
 	"os"
+	"path"
+	"syscall"
 	"time"
 
 	"github.com/pkg/sftp"
 	"github.com/spf13/afero"
 )
 
+var _ afero.Lstater = (*Fs)(nil)
+var _ afero.Symlinker = (*Fs)(nil)
+var _ afero.Chowner = (*Fs)(nil)
+
 // Fs is a afero.Fs implementation that uses functions provided by the sftp package.
 //
 // For details in any method, check the documentation of the sftp package
 // (github.com/pkg/sftp).
+//
+// The errors are *os.PathError, as those of the os package. The SFTP
+// protocol only has a generic failure status for most errors, so the errors
+// expected of an existing file are checked for before the requests.
 type Fs struct {
-	client *sftp.Client
+	pool *pool
 }
 
+// New returns a Fs using client. The Fs fails once the connection of the
+// client is lost: NewPool reconnects.
 func New(client *sftp.Client) afero.Fs {
-	return &Fs{client: client}
+	p := newPool(nil, 1)
+	p.slots[0].conn = watch(client)
+	return &Fs{pool: p}
+}
+
+// NewPool returns a Fs using up to size connections in turn, dialed with
+// dial when they are first needed, for parallel operations to use several
+// SFTP sessions. A lost connection is dialed again. A request that failed as
+// it was lost is sent again, once, on the new connection if it is
+// idempotent: Stat, Open for reading, Chmod and the like. The others, such
+// as Mkdir, Remove or Rename, may have been carried out before the
+// connection was lost and return its error. The Files opened on a lost
+// connection fail with a *ConnectionLostError.
+func NewPool(dial DialFunc, size int) afero.Fs {
+	return &Fs{pool: newPool(dial, size)}
+}
+
+// TransferOptions returns the options of sftp.NewClient for the Files to
+// have up to concurrency requests of packetSize bytes in flight, in their
+// ReadFrom and WriteTo as in their large reads and writes. io.Copy, and so
+// afero.WriteReader and afero.ReadFile, use ReadFrom and WriteTo. Servers
+// should accept packets of up to 32768 bytes, the default size, larger ones
+// may not work with all of them.
+func TransferOptions(concurrency, packetSize int) []sftp.ClientOption {
+	return []sftp.ClientOption{
+		sftp.MaxConcurrentRequestsPerFile(concurrency),
+		sftp.MaxPacketUnchecked(packetSize),
+		sftp.UseConcurrentReads(true),
+		sftp.UseConcurrentWrites(true),
+	}
+}
+
+// Close closes the connections of the Fs.
+func (s Fs) Close() error {
+	return s.pool.close()
+}
+
+// do calls op with a connection of the pool.
+func (s Fs) do(op func(c *conn) error) error {
+	return s.call(false, op)
+}
+
+// retry calls op with a connection of the pool, and again with the new
+// connection of the same slot if the first was lost. op must be idempotent.
+func (s Fs) retry(op func(c *conn) error) error {
+	return s.call(true, op)
+}
+
+func (s Fs) call(retry bool, op func(c *conn) error) error {
+	slot := s.pool.slot()
+	for retried := false; ; retried = true {
+		c, err := s.pool.get(slot)
+		if err != nil {
+			return err
+		}
+		err = op(c)
+		if err == nil || !s.pool.lost(c, err) || !retry || retried {
+			return err
+		}
+	}
 }
 
 func (s Fs) Name() string { return "sftpfs" }
 
 func (s Fs) Create(name string) (afero.File, error) {
-	return FileCreate(s.client, name)
+	return s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 }
 
 func (s Fs) Mkdir(name string, perm os.FileMode) error {
-	err := s.client.Mkdir(name)
-	if err != nil {
-		return err
-	}
-	return s.client.Chmod(name, perm)
+	return pathError("mkdir", name, s.do(func(c *conn) error {
+		if err := c.Mkdir(name); err != nil {
+			if _, err1 := c.Lstat(name); err1 == nil {
+				return os.ErrExist
+			}
+			return err
+		}
+		return c.Chmod(name, perm)
+	}))
 }
 
 func (s Fs) MkdirAll(path string, perm os.FileMode) error {
@@ -54,7 +129,7 @@ func (s Fs) MkdirAll(path string, perm os.FileMode) error {
 		if dir.IsDir() {
 			return nil
 		}
-		return err
+		return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
 	}
 
 	// Slow path: make sure parent exists and then call Mkdir for path.
@@ -91,39 +166,206 @@ func (s Fs) MkdirAll(path string, perm os.FileMode) error {
 }
 
 func (s Fs) Open(name string) (afero.File, error) {
-	return FileOpen(s.client, name)
+	return s.OpenFile(name, os.O_RDONLY, 0)
 }
 
+// OpenFile opens name with the SFTP flags matching flag. perm is set on the
+// file when it is created.
 func (s Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
-	return nil, nil
+	var f *File
+	// the file may have been created or truncated before a connection was lost
+	do := s.retry
+	if flag&(os.O_CREATE|os.O_TRUNC|os.O_EXCL) != 0 {
+		do = s.do
+	}
+	err := do(func(c *conn) error {
+		create := flag&os.O_CREATE != 0
+		if create {
+			_, err := c.Lstat(name)
+			switch {
+			case err == nil && flag&os.O_EXCL != 0:
+				return os.ErrExist
+			case err == nil:
+				create = false
+			case !os.IsNotExist(err):
+				return err
+			}
+		}
+		fd, err := c.OpenFile(name, flag)
+		if err != nil {
+			return err
+		}
+		if create {
+			if err := c.Chmod(name, perm); err != nil {
+				fd.Close()
+				return err
+			}
+		}
+		f = newFile(c, fd, flag)
+		return nil
+	})
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return f, nil
 }
 
 func (s Fs) Remove(name string) error {
-	return s.client.Remove(name)
+	return pathError("remove", name, s.do(func(c *conn) error {
+		return c.Remove(name)
+	}))
 }
 
-func (s Fs) RemoveAll(path string) error {
-	// TODO have a look at os.RemoveAll
-	// https://github.com/golang/go/blob/master/src/os/path.go#L66
+// RemoveAll removes name and, if it is a directory, all it contains, as
+// os.RemoveAll does. Symbolic links are removed, not followed.
+func (s Fs) RemoveAll(name string) error {
+	fi, err := s.Lstat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return pathError("removeall", name, err)
+	}
+	if fi.IsDir() {
+		var infos []os.FileInfo
+		err := s.retry(func(c *conn) (err error) {
+			infos, err = c.ReadDir(name)
+			return err
+		})
+		if err != nil {
+			return pathError("removeall", name, err)
+		}
+		for _, info := range infos {
+			if err := s.RemoveAll(path.Join(name, info.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.Remove(name); err != nil && !os.IsNotExist(err) {
+		return pathError("removeall", name, err)
+	}
 	return nil
 }
 
+// Rename replaces newname if it exists, as os.Rename does, when the server
+// supports the posix-rename@openssh.com extension.
 func (s Fs) Rename(oldname, newname string) error {
-	return s.client.Rename(oldname, newname)
+	err := s.do(func(c *conn) error {
+		if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+			return c.PosixRename(oldname, newname)
+		}
+		return c.Rename(oldname, newname)
+	})
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: unwrap(err)}
+	}
+	return nil
 }
 
 func (s Fs) Stat(name string) (os.FileInfo, error) {
-	return s.client.Stat(name)
+	var fi os.FileInfo
+	err := s.retry(func(c *conn) (err error) {
+		fi, err = c.Stat(name)
+		return err
+	})
+	return fi, pathError("stat", name, err)
 }
 
 func (s Fs) Lstat(p string) (os.FileInfo, error) {
-	return s.client.Lstat(p)
+	var fi os.FileInfo
+	err := s.retry(func(c *conn) (err error) {
+		fi, err = c.Lstat(p)
+		return err
+	})
+	return fi, pathError("lstat", p, err)
+}
+
+func (s Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	fi, err := s.Lstat(name)
+	return fi, true, err
+}
+
+func (s Fs) SymlinkIfPossible(oldname, newname string) error {
+	err := s.do(func(c *conn) error {
+		return c.Symlink(oldname, newname)
+	})
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: unwrap(err)}
+	}
+	return nil
+}
+
+func (s Fs) ReadlinkIfPossible(name string) (string, error) {
+	var target string
+	err := s.retry(func(c *conn) (err error) {
+		target, err = c.ReadLink(name)
+		return err
+	})
+	return target, pathError("readlink", name, err)
 }
 
 func (s Fs) Chmod(name string, mode os.FileMode) error {
-	return s.client.Chmod(name, mode)
+	return pathError("chmod", name, s.retry(func(c *conn) error {
+		return c.Chmod(name, mode)
+	}))
+}
+
+// Chown leaves an id of -1 unchanged, as os.Chown does. The SFTP request
+// sets both ids, so the ones left unchanged are those of the file.
+func (s Fs) Chown(name string, uid, gid int) error {
+	return pathError("chown", name, s.retry(func(c *conn) error {
+		uid, gid := uid, gid
+		if uid == -1 || gid == -1 {
+			fi, err := c.Stat(name)
+			if err != nil {
+				return err
+			}
+			if st, ok := fi.Sys().(*sftp.FileStat); ok {
+				if uid == -1 {
+					uid = int(st.UID)
+				}
+				if gid == -1 {
+					gid = int(st.GID)
+				}
+			}
+		}
+		return c.Chown(name, uid, gid)
+	}))
+}
+
+// Lchown only works on files that are not symbolic links, as there is no
+// SFTP request to change the owner of a link itself.
+func (s Fs) Lchown(name string, uid, gid int) error {
+	fi, err := s.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return &os.PathError{Op: "lchown", Path: name, Err: afero.ErrNoChown}
+	}
+	return s.Chown(name, uid, gid)
 }
 
 func (s Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	return s.client.Chtimes(name, atime, mtime)
+	return pathError("chtimes", name, s.retry(func(c *conn) error {
+		return c.Chtimes(name, atime, mtime)
+	}))
+}
+
+// pathError returns err, returned by the client for the file name, as an
+// *os.PathError.
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: name, Err: unwrap(err)}
+}
+
+// unwrap returns the error wrapped by the client in an *os.PathError, if
+// it did.
+func unwrap(err error) error {
+	if perr, ok := err.(*os.PathError); ok {
+		return perr.Err
+	}
+	return err
 }
@@ -0,0 +1,157 @@
+package sftpfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+)
+
+// newClient returns a client of an in-process SFTP server serving the local
+// filesystem, connected over net.Pipe.
+func newClient(t *testing.T, opts ...sftp.ClientOption) *sftp.Client {
+	c, s := net.Pipe()
+	server, err := sftp.NewServer(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(c, c, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+// newFs returns a Fs of the files in a new temporary directory.
+func newFs(t *testing.T) afero.Fs {
+	dir, err := afero.TempDir(afero.NewOsFs(), "", "sftpfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return afero.NewBasePathFs(New(newClient(t)), dir)
+}
+
+func TestSuite(t *testing.T) {
+	aferotest.Suite{NewFs: newFs}.Run(t)
+}
+
+func TestSymlinks(t *testing.T) {
+	dir, err := afero.TempDir(afero.NewOsFs(), "", "sftpfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := New(newClient(t)).(*Fs)
+
+	fs.MkdirAll(dir+"/kept", 0755)
+	fs.MkdirAll(dir+"/tree", 0755)
+	afero.WriteFile(fs, dir+"/kept/file", []byte("kept"), 0644)
+	if err := fs.SymlinkIfPossible(dir+"/kept", dir+"/tree/link"); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := fs.ReadlinkIfPossible(dir + "/tree/link"); err != nil || target != dir+"/kept" {
+		t.Errorf("Readlink = %q, %v", target, err)
+	}
+	fi, lstat, err := fs.LstatIfPossible(dir + "/tree/link")
+	if err != nil || !lstat || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("LstatIfPossible = %v, %v, %v, want a symbolic link", fi, lstat, err)
+	}
+	if fi, err := fs.Stat(dir + "/tree/link"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat through the link = %v, %v, want a directory", fi, err)
+	}
+
+	// RemoveAll removes the link, not what it links to
+	if err := fs.RemoveAll(dir + "/tree"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(dir + "/tree"); !os.IsNotExist(err) {
+		t.Errorf("Stat of the removed tree returned %v", err)
+	}
+	if data, err := afero.ReadFile(fs, dir+"/kept/file"); err != nil || string(data) != "kept" {
+		t.Errorf("kept/file contains %q, %v", data, err)
+	}
+	if err := fs.RemoveAll(dir + "/missing"); err != nil {
+		t.Errorf("RemoveAll of a missing path returned %v", err)
+	}
+}
+
+// failingReader returns err once its content is read.
+type failingReader struct {
+	io.Reader
+	err error
+}
+
+func (r failingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if err == io.EOF {
+		err = r.err
+	}
+	return n, err
+}
+
+func TestTransfer(t *testing.T) {
+	dir, err := afero.TempDir(afero.NewOsFs(), "", "sftpfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := New(newClient(t, TransferOptions(8, 4096)...))
+
+	data := make([]byte, 1<<20+123)
+	rand.New(rand.NewSource(1)).Read(data)
+	// Hiding the WriterTo of the bytes.Reader, for io.Copy to use ReadFrom
+	if err := afero.WriteReader(fs, dir+"/big", struct{ io.Reader }{bytes.NewReader(data)}); err != nil {
+		t.Fatal(err)
+	}
+	if written, err := ioutil.ReadFile(dir + "/big"); err != nil || !bytes.Equal(written, data) {
+		t.Fatalf("WriteReader wrote %d bytes, %v, want %d", len(written), err, len(data))
+	}
+	if read, err := afero.ReadFile(fs, dir+"/big"); err != nil || !bytes.Equal(read, data) {
+		t.Fatalf("ReadFile read %d bytes, %v, want %d", len(read), err, len(data))
+	}
+
+	f, err := fs.OpenFile(dir+"/big", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.(io.ReaderFrom).ReadFrom(strings.NewReader("tail")); n != 4 || err != nil {
+		t.Errorf("ReadFrom appending = %d, %v", n, err)
+	}
+	if _, err := f.(io.WriterTo).WriteTo(ioutil.Discard); err == nil {
+		t.Error("WriteTo of a file opened for writing only succeeded")
+	}
+	failure := errors.New("failure")
+	if _, err := f.(io.ReaderFrom).ReadFrom(failingReader{strings.NewReader("more"), failure}); err != failure {
+		t.Errorf("ReadFrom of a failing reader returned %v, want its error", err)
+	}
+	f.Close()
+	if fi, err := fs.Stat(dir + "/big"); err != nil || fi.Size() != int64(len(data))+8 {
+		t.Errorf("Stat = %v, %v, want a size of %d", fi, err, len(data)+8)
+	}
+
+	var buf bytes.Buffer
+	f, err = fs.Open(dir + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Seek(int64(len(data)), io.SeekStart)
+	if n, err := io.Copy(&buf, f); n != 8 || err != nil || buf.String() != "tailmore" {
+		t.Errorf("io.Copy from the offset = %d, %q, %v", n, buf.String(), err)
+	}
+}
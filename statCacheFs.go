@@ -0,0 +1,525 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ Symlinker = (*StatCacheFs)(nil)
+var _ Chowner = (*StatCacheFs)(nil)
+
+// The StatCacheFs caches the metadata of a slow source Fs: the results of
+// Stat and LstatIfPossible, and the directory listings read through Readdir
+// and Readdirnames. The paths found not to exist are cached as well, so
+// helpers like Exists, Glob and Walk go to the source once per path.
+//
+// The changes made through the StatCacheFs drop the entries they affect:
+// the path changed, the listing and the metadata of its parent directory,
+// and, for a removal or a rename, the whole tree below the path. Changes
+// made to the source by other means, or through a symbolic link, are seen
+// once the entries expire.
+type StatCacheFs struct {
+	source      Fs
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu     sync.Mutex
+	gen    uint64 // incremented by every invalidation
+	stats  map[string]*statEntry
+	lstats map[string]*statEntry
+	dirs   map[string]*dirEntry
+}
+
+// statEntry is a cached Stat or LstatIfPossible result, err being a not
+// exist error.
+type statEntry struct {
+	fi      os.FileInfo
+	lstat   bool
+	err     error
+	expires time.Time
+}
+
+// dirEntry is a cached directory listing.
+type dirEntry struct {
+	fis     []os.FileInfo
+	expires time.Time
+}
+
+// NewStatCacheFs caches the metadata of source, for ttl if the path exists
+// and for negativeTTL if it does not. A duration of 0 caches the entries
+// until the path is changed through the StatCacheFs, a negative one
+// disables the caching.
+func NewStatCacheFs(source Fs, ttl, negativeTTL time.Duration) Fs {
+	return &StatCacheFs{
+		source:      source,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		stats:       make(map[string]*statEntry),
+		lstats:      make(map[string]*statEntry),
+		dirs:        make(map[string]*dirEntry),
+	}
+}
+
+// expiry returns when an entry added now expires, zero for never, and
+// whether it is cached at all.
+func (u *StatCacheFs) expiry(exists bool) (time.Time, bool) {
+	ttl := u.ttl
+	if !exists {
+		ttl = u.negativeTTL
+	}
+	switch {
+	case ttl < 0:
+		return time.Time{}, false
+	case ttl == 0:
+		return time.Time{}, true
+	}
+	return u.now().Add(ttl), true
+}
+
+func (u *StatCacheFs) expired(expires time.Time) bool {
+	return !expires.IsZero() && u.now().After(expires)
+}
+
+// lookup returns the entry of name in m if it did not expire. It is called
+// with u.mu held.
+func (u *StatCacheFs) lookup(m map[string]*statEntry, name string) *statEntry {
+	e, ok := m[name]
+	if !ok {
+		return nil
+	}
+	if u.expired(e.expires) {
+		delete(m, name)
+		return nil
+	}
+	return e
+}
+
+// store caches the result of a Stat or LstatIfPossible of name in m,
+// unless an invalidation happened since generation gen. It is called with
+// u.mu held.
+func (u *StatCacheFs) store(m map[string]*statEntry, gen uint64, name string, fi os.FileInfo, lstat bool, err error) {
+	if gen != u.gen || err != nil && !os.IsNotExist(err) {
+		return
+	}
+	expires, ok := u.expiry(err == nil)
+	if !ok {
+		return
+	}
+	m[name] = &statEntry{fi: fi, lstat: lstat, err: err, expires: expires}
+}
+
+func (u *StatCacheFs) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	u.mu.Lock()
+	if e := u.lookup(u.stats, name); e != nil {
+		u.mu.Unlock()
+		return e.fi, e.err
+	}
+	gen := u.gen
+	u.mu.Unlock()
+
+	fi, err := u.source.Stat(name)
+	u.mu.Lock()
+	u.store(u.stats, gen, name, fi, false, err)
+	u.mu.Unlock()
+	return fi, err
+}
+
+func (u *StatCacheFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	name = filepath.Clean(name)
+	u.mu.Lock()
+	if e := u.lookup(u.lstats, name); e != nil {
+		u.mu.Unlock()
+		return e.fi, e.lstat, e.err
+	}
+	gen := u.gen
+	u.mu.Unlock()
+
+	var fi os.FileInfo
+	var lstat bool
+	var err error
+	if lstater, ok := u.source.(Lstater); ok {
+		fi, lstat, err = lstater.LstatIfPossible(name)
+	} else {
+		fi, err = u.source.Stat(name)
+	}
+	u.mu.Lock()
+	u.store(u.lstats, gen, name, fi, lstat, err)
+	u.mu.Unlock()
+	return fi, lstat, err
+}
+
+// readdir returns the listing of the directory name, read with read if it
+// is not cached. The entries listed are cached as the LstatIfPossible
+// results of their paths. The listing returned is a copy, the callers may
+// sort it.
+func (u *StatCacheFs) readdir(name string, read func() ([]os.FileInfo, error)) ([]os.FileInfo, error) {
+	u.mu.Lock()
+	if d, ok := u.dirs[name]; ok && !u.expired(d.expires) {
+		u.mu.Unlock()
+		return append([]os.FileInfo{}, d.fis...), nil
+	}
+	gen := u.gen
+	u.mu.Unlock()
+
+	fis, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if fis == nil {
+		fis = []os.FileInfo{}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	expires, ok := u.expiry(true)
+	if !ok || gen != u.gen {
+		return fis, nil
+	}
+	u.dirs[name] = &dirEntry{fis: fis, expires: expires}
+	_, lstat := u.source.(Lstater)
+	for _, fi := range fis {
+		u.lstats[filepath.Join(name, fi.Name())] = &statEntry{fi: fi, lstat: lstat, expires: expires}
+	}
+	return append([]os.FileInfo{}, fis...), nil
+}
+
+// invalidate drops the cached entries of name and of its parent directory.
+// With tree set the entries below name are dropped as well.
+func (u *StatCacheFs) invalidate(name string, tree bool) {
+	name = filepath.Clean(name)
+	parent := filepath.Dir(name)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.gen++
+	for _, p := range []string{name, parent} {
+		delete(u.stats, p)
+		delete(u.lstats, p)
+		delete(u.dirs, p)
+	}
+	if !tree {
+		return
+	}
+	prefix := name + FilePathSeparator
+	if name == FilePathSeparator {
+		prefix = name
+	}
+	for p := range u.stats {
+		if strings.HasPrefix(p, prefix) {
+			delete(u.stats, p)
+		}
+	}
+	for p := range u.lstats {
+		if strings.HasPrefix(p, prefix) {
+			delete(u.lstats, p)
+		}
+	}
+	for p := range u.dirs {
+		if strings.HasPrefix(p, prefix) {
+			delete(u.dirs, p)
+		}
+	}
+}
+
+func (u *StatCacheFs) Create(name string) (File, error) {
+	f, err := u.source.Create(name)
+	u.invalidate(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &StatCacheFile{fs: u, name: filepath.Clean(name), file: f}, nil
+}
+
+func (u *StatCacheFs) Mkdir(name string, perm os.FileMode) error {
+	err := u.source.Mkdir(name, perm)
+	u.invalidate(name, false)
+	return err
+}
+
+func (u *StatCacheFs) MkdirAll(path string, perm os.FileMode) error {
+	err := u.source.MkdirAll(path, perm)
+	// the missing parents are created too
+	for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+		u.invalidate(p, false)
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+	return err
+}
+
+// Open serves a cached directory listing without opening the directory in
+// the source, and a cached not exist error without trying.
+func (u *StatCacheFs) Open(name string) (File, error) {
+	name = filepath.Clean(name)
+	u.mu.Lock()
+	if e := u.lookup(u.stats, name); e != nil && e.err != nil {
+		u.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: underlyingError(e.err)}
+	}
+	if d, ok := u.dirs[name]; ok && !u.expired(d.expires) {
+		u.mu.Unlock()
+		return &StatCacheFile{fs: u, name: name}, nil
+	}
+	gen := u.gen
+	u.mu.Unlock()
+
+	f, err := u.source.Open(name)
+	if err != nil {
+		u.mu.Lock()
+		u.store(u.stats, gen, name, nil, false, err)
+		u.mu.Unlock()
+		return nil, err
+	}
+	return &StatCacheFile{fs: u, name: name, file: f}, nil
+}
+
+func (u *StatCacheFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		return u.Open(name)
+	}
+	f, err := u.source.OpenFile(name, flag, perm)
+	u.invalidate(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &StatCacheFile{fs: u, name: filepath.Clean(name), file: f}, nil
+}
+
+func (u *StatCacheFs) Remove(name string) error {
+	err := u.source.Remove(name)
+	u.invalidate(name, true)
+	return err
+}
+
+func (u *StatCacheFs) RemoveAll(path string) error {
+	err := u.source.RemoveAll(path)
+	u.invalidate(path, true)
+	return err
+}
+
+func (u *StatCacheFs) Rename(oldname, newname string) error {
+	err := u.source.Rename(oldname, newname)
+	u.invalidate(oldname, true)
+	u.invalidate(newname, true)
+	return err
+}
+
+func (u *StatCacheFs) Name() string {
+	return "StatCacheFs"
+}
+
+func (u *StatCacheFs) Chmod(name string, mode os.FileMode) error {
+	err := u.source.Chmod(name, mode)
+	u.invalidate(name, false)
+	return err
+}
+
+func (u *StatCacheFs) Chtimes(name string, atime, mtime time.Time) error {
+	err := u.source.Chtimes(name, atime, mtime)
+	u.invalidate(name, false)
+	return err
+}
+
+func (u *StatCacheFs) Chown(name string, uid, gid int) error {
+	chowner, ok := u.source.(Chowner)
+	if !ok {
+		return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+	}
+	err := chowner.Chown(name, uid, gid)
+	u.invalidate(name, false)
+	return err
+}
+
+func (u *StatCacheFs) Lchown(name string, uid, gid int) error {
+	chowner, ok := u.source.(Chowner)
+	if !ok {
+		return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+	}
+	err := chowner.Lchown(name, uid, gid)
+	u.invalidate(name, false)
+	return err
+}
+
+func (u *StatCacheFs) SymlinkIfPossible(oldname, newname string) error {
+	linker, ok := u.source.(Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+	}
+	err := linker.SymlinkIfPossible(oldname, newname)
+	u.invalidate(newname, false)
+	return err
+}
+
+func (u *StatCacheFs) ReadlinkIfPossible(name string) (string, error) {
+	reader, ok := u.source.(LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+	}
+	return reader.ReadlinkIfPossible(name)
+}
+
+// StatCacheFile is a File opened through a StatCacheFs. Its Readdir and
+// Readdirnames use the cached listing, the writes drop the cached metadata
+// of the file.
+type StatCacheFile struct {
+	fs   *StatCacheFs
+	name string
+	file File // nil until needed for a directory opened from the cache
+	fis  []os.FileInfo
+	off  int
+}
+
+// source returns the file opened in the source Fs.
+func (f *StatCacheFile) source() (File, error) {
+	if f.file == nil {
+		file, err := f.fs.source.Open(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.file = file
+	}
+	return f.file, nil
+}
+
+func (f *StatCacheFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+func (f *StatCacheFile) Read(p []byte) (int, error) {
+	file, err := f.source()
+	if err != nil {
+		return 0, err
+	}
+	return file.Read(p)
+}
+
+func (f *StatCacheFile) ReadAt(p []byte, off int64) (int, error) {
+	file, err := f.source()
+	if err != nil {
+		return 0, err
+	}
+	return file.ReadAt(p, off)
+}
+
+func (f *StatCacheFile) Seek(offset int64, whence int) (int64, error) {
+	if f.fis != nil && offset == 0 && whence == io.SeekStart {
+		f.off = 0
+	}
+	file, err := f.source()
+	if err != nil {
+		return 0, err
+	}
+	return file.Seek(offset, whence)
+}
+
+func (f *StatCacheFile) Write(p []byte) (int, error) {
+	file, err := f.source()
+	if err != nil {
+		return 0, err
+	}
+	defer f.fs.invalidate(f.name, false)
+	return file.Write(p)
+}
+
+func (f *StatCacheFile) WriteAt(p []byte, off int64) (int, error) {
+	file, err := f.source()
+	if err != nil {
+		return 0, err
+	}
+	defer f.fs.invalidate(f.name, false)
+	return file.WriteAt(p, off)
+}
+
+func (f *StatCacheFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *StatCacheFile) Truncate(size int64) error {
+	file, err := f.source()
+	if err != nil {
+		return err
+	}
+	defer f.fs.invalidate(f.name, false)
+	return file.Truncate(size)
+}
+
+func (f *StatCacheFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.fis == nil {
+		fis, err := f.fs.readdir(f.name, func() ([]os.FileInfo, error) {
+			file, err := f.source()
+			if err != nil {
+				return nil, err
+			}
+			return file.Readdir(-1)
+		})
+		if err != nil {
+			return nil, err
+		}
+		f.fis = fis
+	}
+	fis := f.fis[f.off:]
+	if count > 0 {
+		if len(fis) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(fis) {
+			fis = fis[:count]
+		}
+	}
+	f.off += len(fis)
+	// A copy, for the caller not to change the entries still to be returned
+	return append([]os.FileInfo{}, fis...), nil
+}
+
+func (f *StatCacheFile) Readdirnames(n int) ([]string, error) {
+	fis, err := f.Readdir(n)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	return names, nil
+}
+
+func (f *StatCacheFile) Name() string {
+	if f.file != nil {
+		return f.file.Name()
+	}
+	return f.name
+}
+
+func (f *StatCacheFile) Stat() (os.FileInfo, error) {
+	return f.fs.Stat(f.name)
+}
+
+func (f *StatCacheFile) Sync() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
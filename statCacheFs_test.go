@@ -0,0 +1,158 @@
+package afero
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// countingFs counts the metadata requests reaching an Fs.
+type countingFs struct {
+	Fs
+	calls int
+}
+
+func (c *countingFs) Stat(name string) (os.FileInfo, error) {
+	c.calls++
+	return c.Fs.Stat(name)
+}
+
+func (c *countingFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	c.calls++
+	return c.Fs.(Lstater).LstatIfPossible(name)
+}
+
+func (c *countingFs) Open(name string) (File, error) {
+	c.calls++
+	return c.Fs.Open(name)
+}
+
+func TestStatCacheFs(t *testing.T) {
+	dir, err := TempDir(NewOsFs(), "", "afero-statcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := &countingFs{Fs: NewBasePathFs(NewOsFs(), dir)}
+	fs := NewStatCacheFs(source, 0, 0)
+	source.MkdirAll("/dir/sub", 0755)
+	WriteFile(source, "/dir/a", []byte("a"), 0644)
+	WriteFile(source, "/dir/sub/b", []byte("b"), 0644)
+
+	cached := func(what string, f func()) {
+		t.Helper()
+		f()
+		calls := source.calls
+		f()
+		if source.calls != calls {
+			t.Errorf("%s: %d requests reached the source, want none", what, source.calls-calls)
+		}
+	}
+	cached("Stat", func() { fs.Stat("/dir/a") })
+	cached("Exists", func() { Exists(fs, "/dir/missing") })
+	cached("Walk", func() {
+		Walk(fs, "/dir", func(path string, info os.FileInfo, err error) error { return err })
+	})
+	cached("Glob", func() { Glob(fs, "/dir/*/b") })
+
+	// A new file shows up in the listing and in place of the negative entry
+	if _, err := fs.Create("/dir/missing"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := Exists(fs, "/dir/missing"); !ok {
+		t.Error("/dir/missing created but does not exist")
+	}
+	names, _ := ReadDir(fs, "/dir")
+	if len(names) != 3 {
+		t.Errorf("got %d entries in /dir, want 3", len(names))
+	}
+
+	// Writes through a handle change the size
+	f, err := fs.OpenFile("/dir/a", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("bc")
+	f.Close()
+	if fi, err := fs.Stat("/dir/a"); err != nil || fi.Size() != 3 {
+		t.Errorf("Stat after the write: %v, %v, want a size of 3", fi, err)
+	}
+
+	// The tree below a renamed directory is dropped, not just the directory
+	if err := fs.Rename("/dir/sub", "/dir/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/dir/sub/b"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a renamed file returned %v, want a not exist error", err)
+	}
+	if _, err := fs.Stat("/dir/moved/b"); err != nil {
+		t.Error(err)
+	}
+
+	// Only the entries affected are dropped
+	fs.Stat("/dir/moved/b")
+	calls := source.calls
+	fs.Chmod("/dir/a", 0600)
+	fs.Stat("/dir/moved/b")
+	if source.calls != calls {
+		t.Error("Chmod of /dir/a dropped the entry of /dir/moved/b")
+	}
+	if fi, _ := fs.Stat("/dir/a"); fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v after Chmod, want 0600", fi.Mode())
+	}
+}
+
+func TestStatCacheFsTTL(t *testing.T) {
+	source := NewMemMapFs()
+	fs := NewStatCacheFs(source, time.Minute, time.Second).(*StatCacheFs)
+	now := time.Now()
+	fs.now = func() time.Time { return now }
+
+	WriteFile(source, "/a", []byte("a"), 0644)
+	fs.Stat("/a")
+	fs.Stat("/b")
+	// Changes made behind the cache's back
+	source.Remove("/a")
+	WriteFile(source, "/b", []byte("b"), 0644)
+
+	now = now.Add(2 * time.Second)
+	if _, err := fs.Stat("/a"); err != nil {
+		t.Errorf("Stat of /a returned %v before it expired", err)
+	}
+	if _, err := fs.Stat("/b"); err != nil {
+		t.Errorf("negative entry of /b not expired: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := fs.Stat("/a"); !os.IsNotExist(err) {
+		t.Errorf("entry of /a not expired: %v", err)
+	}
+}
+
+func TestStatCacheFsListingCopies(t *testing.T) {
+	source := NewMemMapFs()
+	source.Mkdir("/dir", 0755)
+	for _, name := range []string{"/dir/a", "/dir/b", "/dir/c"} {
+		WriteFile(source, name, []byte(name), 0644)
+	}
+	fs := NewStatCacheFs(source, 0, 0)
+
+	readdir := func(count int) []os.FileInfo {
+		t.Helper()
+		f, err := fs.Open("/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		fis, err := f.Readdir(count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fis
+	}
+	fis := readdir(-1)
+	fis[0], fis[2] = fis[2], fis[0]
+	_ = append(readdir(2), nil)
+	if fis := readdir(-1); len(fis) != 3 || fis[0].Name() != "a" || fis[2] == nil || fis[2].Name() != "c" {
+		t.Errorf("a listing changed by the caller of an earlier one: %v", fis)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.File = (*File)(nil)
+
+// File is an open entry of a tar archive. Its content can be read and, as
+// several Files may read the same content, ReadAt is safe to call
+// concurrently.
+type File struct {
+	name    string
+	e       *entry
+	content *io.SectionReader
+	dirAt   int
+	closed  bool
+}
+
+func newFile(name string, e *entry) *File {
+	var content io.ReaderAt = bytes.NewReader(nil)
+	size := int64(0)
+	if e.content != nil {
+		content, size = e.content, e.hdr.Size
+	}
+	return &File{name: name, e: e, content: io.NewSectionReader(content, 0, size)}
+}
+
+func (f *File) Name() string { return f.name }
+
+func (f *File) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, afero.ErrFileClosed
+	}
+	return fileInfo(f.name, f.e), nil
+}
+
+func (f *File) Close() error {
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *File) Read(b []byte) (int, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+	if f.e.hdr.FileInfo().IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	return f.content.Read(b)
+}
+
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+	if f.e.hdr.FileInfo().IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	return f.content.ReadAt(b, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+	return f.content.Seek(offset, whence)
+}
+
+// Readdir returns the entries of the directory in the order of their
+// names, count at most if count is positive, as os.File.Readdir does.
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, afero.ErrFileClosed
+	}
+	if !f.e.hdr.FileInfo().IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	children := f.e.children[f.dirAt:]
+	if count > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		if len(children) > count {
+			children = children[:count]
+		}
+	}
+	f.dirAt += len(children)
+	infos := make([]os.FileInfo, len(children))
+	for i, child := range children {
+		infos[i] = child.hdr.FileInfo()
+	}
+	return infos, nil
+}
+
+func (f *File) Readdirnames(n int) ([]string, error) {
+	infos, err := f.Readdir(n)
+	names := make([]string, len(infos))
+	for i, fi := range infos {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
+
+func (f *File) Sync() error {
+	return nil
+}
+
+func (f *File) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *File) Write(b []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
@@ -0,0 +1,352 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tarfs provides a read-only afero.Fs over the entries of a tar
+// archive, compressed with gzip or not.
+package tarfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.Lstater = (*Fs)(nil)
+var _ afero.LinkReader = (*Fs)(nil)
+var _ afero.Chowner = (*Fs)(nil)
+
+// maxSymlinks is the number of symbolic links followed while resolving a
+// single path before giving up with ELOOP.
+const maxSymlinks = 40
+
+// Fs is a read-only afero.Fs over the entries of a tar archive, indexed
+// when the Fs is created. The directories only implied by the paths of the
+// entries are added, and an entry replaces the earlier ones with the same
+// name, as when the archive is extracted. Paths are relative to the root of
+// the archive, the targets of absolute symbolic links included.
+//
+// The methods modifying the filesystem fail with syscall.EPERM, as those of
+// afero.ReadOnlyFs do.
+type Fs struct {
+	entries map[string]*entry
+}
+
+// entry is a file of the archive, by the clean slash separated absolute
+// path it has in the Fs.
+type entry struct {
+	hdr      *tar.Header
+	content  io.ReaderAt
+	children []*entry
+}
+
+// New returns a Fs with the entries of the tar archive read from r, which
+// may be gzip compressed. The content of the files is kept in memory.
+func New(r io.Reader) (afero.Fs, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); isGzip(magic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return newFs(tar.NewReader(zr), nil)
+	}
+	return newFs(tar.NewReader(br), nil)
+}
+
+// NewFromReaderAt returns a Fs with the entries of the tar archive in the
+// size first bytes of r. Only the headers are read to index the archive,
+// the content of the files is read from r when they are, so r must stay
+// available as long as the Fs is used. A gzip compressed archive cannot be
+// read at random: it is read as with New.
+func NewFromReaderAt(r io.ReaderAt, size int64) (afero.Fs, error) {
+	magic := make([]byte, 2)
+	if n, _ := r.ReadAt(magic, 0); isGzip(magic[:n]) {
+		return New(io.NewSectionReader(r, 0, size))
+	}
+	sr := io.NewSectionReader(r, 0, size)
+	return newFs(tar.NewReader(sr), sr)
+}
+
+func isGzip(magic []byte) bool {
+	return len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// newFs indexes the archive read by tr. If sr is set, tr reads from it and
+// the content of the regular files is left in sr: tar.Reader skips it with
+// Seek, reading only the headers.
+func newFs(tr *tar.Reader, sr *io.SectionReader) (*Fs, error) {
+	fs := &Fs{entries: map[string]*entry{
+		"/": {hdr: &tar.Header{Name: "/", Typeflag: tar.TypeDir, Mode: 0755}},
+	}}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := clean(hdr.Name)
+		hdr.Name = name
+		e := &entry{hdr: hdr}
+		switch {
+		case hdr.Typeflag == tar.TypeLink:
+			target, ok := fs.entries[clean(hdr.Linkname)]
+			if !ok {
+				return nil, &os.PathError{Op: "link", Path: hdr.Linkname, Err: os.ErrNotExist}
+			}
+			// A hard link is the same file under another name
+			linked := *target.hdr
+			linked.Name = name
+			e = &entry{hdr: &linked, content: target.content}
+		case hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA || hdr.Typeflag == tar.TypeGNUSparse:
+			if sr != nil && !isSparse(hdr) {
+				off, err := sr.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return nil, err
+				}
+				e.content = io.NewSectionReader(sr, off, hdr.Size)
+				break
+			}
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			e.content = bytes.NewReader(data)
+		}
+		if name == "/" {
+			// The root stays a directory
+			if hdr.Typeflag != tar.TypeDir {
+				continue
+			}
+		} else {
+			fs.addParents(name, hdr.ModTime)
+		}
+		fs.entries[name] = e
+	}
+	fs.index()
+	return fs, nil
+}
+
+// isSparse reports whether the content of the file described by hdr has to
+// be read through the tar.Reader to fill its holes.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// addParents adds the directories above name missing from the index.
+func (fs *Fs) addParents(name string, mtime time.Time) {
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if e, ok := fs.entries[dir]; ok && e.hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		fs.entries[dir] = &entry{hdr: &tar.Header{
+			Name:     dir,
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+			ModTime:  mtime,
+		}}
+	}
+}
+
+// index fills the lists of entries of the directories, sorted by name.
+func (fs *Fs) index() {
+	for name, e := range fs.entries {
+		if name == "/" {
+			continue
+		}
+		if parent, ok := fs.entries[path.Dir(name)]; ok {
+			parent.children = append(parent.children, e)
+		}
+	}
+	for _, e := range fs.entries {
+		children := e.children
+		sort.Slice(children, func(i, j int) bool { return children[i].hdr.Name < children[j].hdr.Name })
+	}
+}
+
+// clean returns the path of name in the index.
+func clean(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// resolve follows the symbolic links in name and returns the path of the
+// entry it refers to, the last element only if followLast is set.
+// Elements that do not exist are left as they are, the caller will notice.
+func (fs *Fs) resolve(name string, followLast bool) (string, error) {
+	name = clean(name)
+	hops := 0
+resolve:
+	for {
+		parts := strings.Split(name, "/")
+		cur := "/"
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			next := path.Join(cur, part)
+			e, ok := fs.entries[next]
+			if !ok {
+				return name, nil
+			}
+			if e.hdr.Typeflag == tar.TypeSymlink && (followLast || i < len(parts)-1) {
+				if hops++; hops > maxSymlinks {
+					return name, syscall.ELOOP
+				}
+				target := e.hdr.Linkname
+				if !path.IsAbs(target) {
+					target = path.Join(cur, target)
+				}
+				name = clean(path.Join(append([]string{target}, parts[i+1:]...)...))
+				continue resolve
+			}
+			cur = next
+		}
+		return name, nil
+	}
+}
+
+// lookup returns the entry at name.
+func (fs *Fs) lookup(op, name string, followLast bool) (*entry, error) {
+	resolved, err := fs.resolve(name, followLast)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	e, ok := fs.entries[resolved]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (fs *Fs) Name() string { return "tarfs" }
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	e, err := fs.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return newFile(name, e), nil
+}
+
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, syscall.EPERM
+	}
+	return fs.Open(name)
+}
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	e, err := fs.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo(name, e), nil
+}
+
+func (fs *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	e, err := fs.lookup("lstat", name, false)
+	if err != nil {
+		return nil, true, err
+	}
+	return e.hdr.FileInfo(), true, nil
+}
+
+func (fs *Fs) ReadlinkIfPossible(name string) (string, error) {
+	e, err := fs.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e.hdr.Typeflag != tar.TypeSymlink {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return e.hdr.Linkname, nil
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return nil, syscall.EPERM
+}
+
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Remove(name string) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) RemoveAll(path string) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Rename(oldname, newname string) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Chown(name string, uid, gid int) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Lchown(name string, uid, gid int) error {
+	return syscall.EPERM
+}
+
+// fileInfo describes e, found at name, under the base name of name: as
+// with os.Stat, a file reached through a symbolic link is named after it.
+func fileInfo(name string, e *entry) os.FileInfo {
+	fi := e.hdr.FileInfo()
+	if base := path.Base(clean(name)); base != fi.Name() {
+		return &namedFileInfo{FileInfo: fi, name: base}
+	}
+	return fi
+}
+
+type namedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *namedFileInfo) Name() string { return fi.name }
@@ -0,0 +1,237 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+)
+
+// archive writes the content of fs to a tar archive.
+func archive(t *testing.T, fs afero.Fs) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	err := afero.Walk(fs, "/", func(name string, fi os.FileInfo, err error) error {
+		if err != nil || name == "/" {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = strings.TrimPrefix(filepath.ToSlash(name), "/")
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			data, err := afero.ReadFile(fs, name)
+			if err != nil {
+				return err
+			}
+			_, err = tw.Write(data)
+			return err
+		}
+		return nil
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newMemMapFs(t *testing.T) afero.Fs {
+	return afero.NewMemMapFs()
+}
+
+func TestSuite(t *testing.T) {
+	aferotest.Suite{NewFs: newMemMapFs, ReadOnly: func(fs afero.Fs) afero.Fs {
+		tfs, err := New(bytes.NewReader(archive(t, fs)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tfs
+	}}.Run(t)
+}
+
+func TestSuiteReaderAt(t *testing.T) {
+	aferotest.Suite{NewFs: newMemMapFs, ReadOnly: func(fs afero.Fs) afero.Fs {
+		data := archive(t, fs)
+		tfs, err := NewFromReaderAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tfs
+	}}.Run(t)
+}
+
+// entries writes a tar archive of the given headers, the regular files
+// holding their name as content.
+func entries(t *testing.T, hdrs ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(hdr.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkContent(t *testing.T, fs afero.Fs, name, want string) {
+	t.Helper()
+	data, err := afero.ReadFile(fs, name)
+	if err != nil || string(data) != want {
+		t.Errorf("%s contains %q, %v, want %q", name, data, err, want)
+	}
+}
+
+func TestFs(t *testing.T) {
+	mtime := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := entries(t,
+		&tar.Header{Name: "./etc/conf", Typeflag: tar.TypeReg, ModTime: mtime},
+		&tar.Header{Name: "etc/old", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "etc/old", Typeflag: tar.TypeSymlink, Linkname: "conf"},
+		&tar.Header{Name: "/usr/share/doc/README", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "usr/share/", Typeflag: tar.TypeDir, Mode: 0700},
+		&tar.Header{Name: "usr/lib", Typeflag: tar.TypeSymlink, Linkname: "/usr/share"},
+		&tar.Header{Name: "etc/hard", Typeflag: tar.TypeLink, Linkname: "etc/conf"},
+		&tar.Header{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "loop"},
+	)
+	fs, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkContent(t, fs, "/etc/conf", "./etc/conf")
+	checkContent(t, fs, "etc/hard", "./etc/conf")
+	// Later entries replace the earlier ones
+	checkContent(t, fs, "/etc/old", "./etc/conf")
+	checkContent(t, fs, "/usr/lib/doc/README", "/usr/share/doc/README")
+
+	// Implicit directories are synthesized, explicit ones kept
+	for name, mode := range map[string]os.FileMode{
+		"/":          os.ModeDir | 0755,
+		"/etc":       os.ModeDir | 0755,
+		"/usr":       os.ModeDir | 0755,
+		"/usr/share": os.ModeDir | 0700,
+	} {
+		if fi, err := fs.Stat(name); err != nil || fi.Mode() != mode {
+			t.Errorf("Stat(%q) = %v, %v, want a mode of %v", name, fi, err, mode)
+		}
+	}
+	fi, err := fs.Stat("/etc/hard")
+	if err != nil || fi.Name() != "hard" || fi.Size() != int64(len("./etc/conf")) || !fi.ModTime().Equal(mtime) {
+		t.Errorf("Stat of a hard link = %v, %v", fi, err)
+	}
+	if fi, _, err := fs.(afero.Lstater).LstatIfPossible("/usr/lib"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat of a symbolic link = %v, %v", fi, err)
+	}
+	if target, err := fs.(afero.LinkReader).ReadlinkIfPossible("/usr/lib"); err != nil || target != "/usr/share" {
+		t.Errorf("Readlink = %q, %v", target, err)
+	}
+	if _, err := fs.Stat("/loop"); err == nil || !strings.Contains(err.Error(), "too many levels") {
+		t.Errorf("Stat of a symbolic link loop = %v", err)
+	}
+
+	var walked []string
+	afero.Walk(fs, "/", func(name string, fi os.FileInfo, err error) error {
+		walked = append(walked, name)
+		return err
+	})
+	want := []string{"/", "/etc", "/etc/conf", "/etc/hard", "/etc/old", "/loop",
+		"/usr", "/usr/lib", "/usr/share", "/usr/share/doc", "/usr/share/doc/README"}
+	if strings.Join(walked, " ") != strings.Join(want, " ") {
+		t.Errorf("walked %v, want %v", walked, want)
+	}
+	matches, err := afero.Glob(fs, "/*/*/doc")
+	if err != nil || strings.Join(matches, " ") != "/usr/lib/doc /usr/share/doc" {
+		t.Errorf("Glob = %v, %v", matches, err)
+	}
+
+	if err := fs.Remove("/etc/conf"); err != syscall.EPERM {
+		t.Errorf("Remove returned %v, want EPERM", err)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(entries(t, &tar.Header{Name: "a/b", Typeflag: tar.TypeReg}))
+	zw.Close()
+
+	fs, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, fs, "/a/b", "a/b")
+	fs, err = NewFromReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, fs, "/a/b", "a/b")
+}
+
+// countingReaderAt counts the bytes read from a ReaderAt.
+type countingReaderAt struct {
+	io.ReaderAt
+	n int
+}
+
+func (r *countingReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(b, off)
+	r.n += n
+	return n, err
+}
+
+func TestReaderAtIndexesHeadersOnly(t *testing.T) {
+	big := &tar.Header{Name: "big", Typeflag: tar.TypeReg, Mode: 0644, Size: 10 << 20}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(big)
+	tw.Write(bytes.Repeat([]byte("x"), int(big.Size)))
+	tw.WriteHeader(&tar.Header{Name: "small", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	tw.Write([]byte("small"))
+	tw.Close()
+
+	r := &countingReaderAt{ReaderAt: bytes.NewReader(buf.Bytes())}
+	fs, err := NewFromReaderAt(r, int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.n > 64<<10 {
+		t.Errorf("%d bytes read to index the archive", r.n)
+	}
+	checkContent(t, fs, "/small", "small")
+	f, _ := fs.Open("/big")
+	b := make([]byte, 4)
+	if n, err := f.ReadAt(b, big.Size-4); err != nil || string(b[:n]) != "xxxx" {
+		t.Errorf("ReadAt at the end of a large file = %q, %v", b[:n], err)
+	}
+	if _, err := f.Readdir(-1); err == nil {
+		t.Error("Readdir of a regular file succeeded")
+	}
+}
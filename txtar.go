@@ -0,0 +1,178 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrTxtarMarker is the error wrapped in the os.PathError returned when a
+// file cannot be written to a txtar archive, as a line of its content would
+// be read back as the start of another file.
+var ErrTxtarMarker = errors.New("content has a txtar file marker line")
+
+// LoadTxtar writes the files of the txtar archive data under the directory
+// dir, creating it and the parent directories of the files as needed. The
+// format is that of golang.org/x/tools/txtar: a comment, ignored, followed
+// by files, each starting with a "-- name --" line. A name ending with a
+// slash is that of an empty directory. Files are created with the mode
+// 0644 and directories with 0755.
+//
+// This is meant for test fixtures:
+//
+//	fs := afero.NewMemMapFs()
+//	err := afero.LoadTxtar(fs, "/", []byte(`
+//	-- go.mod --
+//	module example.com/m
+//	-- cmd/main.go --
+//	package main
+//	`))
+//
+// Names escaping dir are rejected with ErrUnsafeArchivePath, as with
+// ExtractTar.
+func (a Afero) LoadTxtar(dir string, data []byte) error {
+	return LoadTxtar(a.Fs, dir, data)
+}
+
+func LoadTxtar(fs Fs, dir string, data []byte) error {
+	x := &extractor{fs: fs, dir: dir}
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range parseTxtar(data) {
+		p, err := x.path(f.name)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(f.name, "/") {
+			if err := fs.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fs.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := WriteFile(fs, p, f.data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DumpTxtar returns the tree rooted at root as a txtar archive, LoadTxtar
+// reading it back. The names of the files are relative to root, in lexical
+// order, and the empty directories are kept. As in the txtar format, a
+// newline is added to a content not ending with one. Files other than
+// regular files and directories are left out.
+func (a Afero) DumpTxtar(root string) ([]byte, error) {
+	return DumpTxtar(a.Fs, root)
+}
+
+func DumpTxtar(fs Fs, root string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := Walk(fs, root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			if fi.IsDir() {
+				return nil
+			}
+			rel = fi.Name()
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case fi.IsDir():
+			names, err := readDirNames(fs, name)
+			if err != nil {
+				return err
+			}
+			if len(names) == 0 {
+				buf.WriteString("-- " + rel + "/ --\n")
+			}
+		case fi.Mode().IsRegular():
+			data, err := ReadFile(fs, name)
+			if err != nil {
+				return err
+			}
+			for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+				if _, ok := txtarMarker(line); ok {
+					return &os.PathError{Op: "dump", Path: name, Err: ErrTxtarMarker}
+				}
+			}
+			buf.WriteString("-- " + rel + " --\n")
+			buf.Write(data)
+			if len(data) > 0 && data[len(data)-1] != '\n' {
+				buf.WriteByte('\n')
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// txtarFile is a file of a txtar archive.
+type txtarFile struct {
+	name string
+	data []byte
+}
+
+// parseTxtar returns the files of the txtar archive data, in order.
+func parseTxtar(data []byte) []txtarFile {
+	var files []txtarFile
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i+1], data[i+1:]
+		} else {
+			data = nil
+		}
+		if name, ok := txtarMarker(line); ok {
+			files = append(files, txtarFile{name: name})
+		} else if len(files) > 0 {
+			last := &files[len(files)-1]
+			last.data = append(last.data, line...)
+		}
+	}
+	if len(files) > 0 {
+		last := &files[len(files)-1]
+		if len(last.data) > 0 && last.data[len(last.data)-1] != '\n' {
+			last.data = append(last.data, '\n')
+		}
+	}
+	return files
+}
+
+// txtarMarker returns the name in line if it is a file marker line.
+func txtarMarker(line []byte) (string, bool) {
+	s := strings.TrimSuffix(string(line), "\n")
+	if len(s) < len("-- ")+len(" --") || !strings.HasPrefix(s, "-- ") || !strings.HasSuffix(s, " --") {
+		return "", false
+	}
+	name := strings.TrimSpace(s[len("-- ") : len(s)-len(" --")])
+	return name, name != ""
+}
@@ -0,0 +1,71 @@
+package afero
+
+import (
+	"os"
+	"testing"
+)
+
+const txtarFixture = `A comment, ignored.
+-- go.mod --
+module example.com/m
+-- cmd/tool/main.go --
+package main
+
+func main() {}
+-- empty/ --
+-- noeol --
+last`
+
+func TestLoadTxtar(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := LoadTxtar(fs, "/src", []byte(txtarFixture)); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"/src/go.mod":           "module example.com/m\n",
+		"/src/cmd/tool/main.go": "package main\n\nfunc main() {}\n",
+		"/src/noeol":            "last\n",
+	} {
+		data, err := ReadFile(fs, name)
+		if err != nil || string(data) != want {
+			t.Errorf("%s contains %q, %v, want %q", name, data, err, want)
+		}
+	}
+	if fi, err := fs.Stat("/src/empty"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat(/src/empty) = %v, %v, want a directory", fi, err)
+	}
+
+	err := LoadTxtar(fs, "/src", []byte("-- ../evil --\n"))
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != ErrUnsafeArchivePath {
+		t.Errorf("loading a file outside of the directory returned %v", err)
+	}
+}
+
+func TestDumpTxtar(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := LoadTxtar(fs, "/src", []byte(txtarFixture)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := DumpTxtar(fs, "/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `-- cmd/tool/main.go --
+package main
+
+func main() {}
+-- empty/ --
+-- go.mod --
+module example.com/m
+-- noeol --
+last
+`
+	if string(data) != want {
+		t.Errorf("DumpTxtar = %q, want %q", data, want)
+	}
+
+	WriteFile(fs, "/src/marker", []byte("a\n-- b --\n"), 0644)
+	if _, err := DumpTxtar(fs, "/src"); err == nil || err.(*os.PathError).Err != ErrTxtarMarker {
+		t.Errorf("dumping a content with a marker line returned %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 )
 
@@ -14,7 +15,8 @@ import (
 // The calls to
 // Readdir() and Readdirnames() merge the file os.FileInfo / names from the
 // base and the overlay - for files present in both layers, only those
-// from the overlay will be used.
+// from the overlay will be used. Opened by a CopyOnWriteFs, the whiteouts in
+// the overlay hide the files they stand for, and are not listed themselves.
 //
 // When opening files for writing (Create() / OpenFile() with the right flags)
 // the operations will be done in both layers, starting with the overlay. A
@@ -26,6 +28,8 @@ type UnionFile struct {
 	Merger DirsMerger
 	off    int
 	files  []os.FileInfo
+
+	whiteouts bool
 }
 
 func (f *UnionFile) Close() error {
@@ -154,6 +158,33 @@ var defaultUnionMergeDirsFn = func(lofi, bofi []os.FileInfo) ([]os.FileInfo, err
 
 }
 
+// applyWhiteouts removes the whiteouts from the overlay listing, and the
+// entries they hide from the base listing.
+func applyWhiteouts(lofi, bofi []os.FileInfo) ([]os.FileInfo, []os.FileInfo) {
+	var layer, base []os.FileInfo
+	hidden := make(map[string]bool)
+	opaque := false
+	for _, fi := range lofi {
+		switch name := fi.Name(); {
+		case name == whiteoutOpaque:
+			opaque = true
+		case strings.HasPrefix(name, whiteoutPrefix):
+			hidden[strings.TrimPrefix(name, whiteoutPrefix)] = true
+		default:
+			layer = append(layer, fi)
+		}
+	}
+	if opaque {
+		return layer, nil
+	}
+	for _, fi := range bofi {
+		if !hidden[fi.Name()] {
+			base = append(base, fi)
+		}
+	}
+	return layer, base
+}
+
 // Readdir will weave the two directories together and
 // return a single view of the overlayed directories
 func (f *UnionFile) Readdir(c int) (ofi []os.FileInfo, err error) {
@@ -162,7 +193,7 @@ func (f *UnionFile) Readdir(c int) (ofi []os.FileInfo, err error) {
 		merge = defaultUnionMergeDirsFn
 	}
 
-	if f.off == 0 {
+	if f.files == nil {
 		var lfi []os.FileInfo
 		if f.Layer != nil {
 			lfi, err = f.Layer.Readdir(-1)
@@ -179,17 +210,28 @@ func (f *UnionFile) Readdir(c int) (ofi []os.FileInfo, err error) {
 			}
 
 		}
+		if f.whiteouts {
+			lfi, bfi = applyWhiteouts(lfi, bfi)
+		}
 		merged, err := merge(lfi, bfi)
 		if err != nil {
 			return nil, err
 		}
-		f.files = append(f.files, merged...)
+		f.files = append([]os.FileInfo{}, merged...)
 	}
-	if c == -1 {
-		return f.files[f.off:], nil
+	files := f.files[f.off:]
+	if c <= 0 {
+		f.off = len(f.files)
+		return files, nil
 	}
-	defer func() { f.off += c }()
-	return f.files[f.off:c], nil
+	if len(files) == 0 {
+		return nil, io.EOF
+	}
+	if c > len(files) {
+		c = len(files)
+	}
+	f.off += c
+	return files[:c], nil
 }
 
 func (f *UnionFile) Readdirnames(c int) ([]string, error) {
@@ -264,16 +306,9 @@ func copyToLayer(base Fs, layer Fs, name string) error {
 	defer bfh.Close()
 
 	// First make sure the directory exists
-	exists, err := Exists(layer, filepath.Dir(name))
-	if err != nil {
+	if err := copyDirsToLayer(base, layer, filepath.Dir(name)); err != nil {
 		return err
 	}
-	if !exists {
-		err = layer.MkdirAll(filepath.Dir(name), 0777) // FIXME?
-		if err != nil {
-			return err
-		}
-	}
 
 	// Create the file on the overlay
 	lfh, err := layer.Create(name)
@@ -301,5 +336,29 @@ func copyToLayer(base Fs, layer Fs, name string) error {
 		lfh.Close()
 		return err
 	}
+	if err := layer.Chmod(name, bfi.Mode()); err != nil {
+		return err
+	}
 	return layer.Chtimes(name, bfi.ModTime(), bfi.ModTime())
 }
+
+// copyDirsToLayer creates dir and its missing parents in layer, with the
+// permissions they have in base.
+func copyDirsToLayer(base Fs, layer Fs, dir string) error {
+	if _, err := layer.Stat(dir); err == nil {
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := copyDirsToLayer(base, layer, parent); err != nil {
+			return err
+		}
+	}
+	perm := os.FileMode(0777)
+	if fi, err := base.Stat(dir); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	if err := layer.Mkdir(dir, perm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
@@ -173,6 +173,7 @@ func TestReaderContains(t *testing.T) {
 
 func createZeroSizedFileInTempDir() (File, error) {
 	filePrefix := "_path_test_"
+	tempDir(testFS)
 	f, e := TempFile(testFS, "", filePrefix) // dir is os.TempDir()
 	if e != nil {
 		// if there was an error no file was created.
@@ -0,0 +1,404 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Op describes a set of file operations reported by a Watcher.
+type Op uint32
+
+// The operations reported in an Event.
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+	Chmod
+)
+
+var opNames = []struct {
+	op   Op
+	name string
+}{
+	{Create, "CREATE"},
+	{Write, "WRITE"},
+	{Remove, "REMOVE"},
+	{Rename, "RENAME"},
+	{Chmod, "CHMOD"},
+}
+
+func (op Op) String() string {
+	var names []string
+	for _, o := range opNames {
+		if op&o.op != 0 {
+			names = append(names, o.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Event is a change to a file or directory under a watched path.
+// A renamed file is reported as a Rename of the old name followed by a
+// Create of the new one.
+type Event struct {
+	Name string
+	Op   Op
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%q: %s", e.Name, e.Op)
+}
+
+// Watch is a running watch. Events are queued until they are read, so a
+// slow reader never blocks the filesystem. Both channels are closed by Close.
+type Watch interface {
+	Events() <-chan Event
+	Errors() <-chan error
+	Close() error
+}
+
+// Watcher is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// Watch reports the changes to name and, if name is a directory, to its
+// entries. With recursive set it reports the changes to the whole tree
+// below name.
+type Watcher interface {
+	Watch(name string, recursive bool) (Watch, error)
+}
+
+// DefaultPollInterval is the interval at which NewWatch polls the
+// filesystems that do not implement Watcher.
+var DefaultPollInterval = time.Second
+
+// NewWatch watches name in fs. It uses the Watcher of fs if it has one,
+// else it falls back to a PollingWatcher.
+func NewWatch(fs Fs, name string, recursive bool) (Watch, error) {
+	if w, ok := fs.(Watcher); ok {
+		return w.Watch(name, recursive)
+	}
+	return NewPollingWatcher(fs, DefaultPollInterval).Watch(name, recursive)
+}
+
+// watchCovers reports whether a watch on root reports changes to name.
+func watchCovers(root, name string, recursive bool) bool {
+	if name == root {
+		return true
+	}
+	if recursive {
+		prefix := root
+		if !strings.HasSuffix(prefix, FilePathSeparator) {
+			prefix += FilePathSeparator
+		}
+		return strings.HasPrefix(name, prefix)
+	}
+	return filepath.Dir(name) == root
+}
+
+// eventQueue is the Watch the watchers in this package hand out. The
+// watcher pushes to it, a goroutine feeds the channels from the queue.
+type eventQueue struct {
+	events chan Event
+	errors chan error
+	wake   chan struct{}
+	done   chan struct{}
+	stop   func() error
+
+	mu     sync.Mutex
+	queue  []Event
+	errs   []error
+	closed bool
+}
+
+// newEventQueue starts an eventQueue; stop, if not nil, is called once on
+// Close to release what the watcher holds.
+func newEventQueue(stop func() error) *eventQueue {
+	q := &eventQueue{
+		events: make(chan Event),
+		errors: make(chan error),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		stop:   stop,
+	}
+	go q.loop()
+	return q
+}
+
+func (q *eventQueue) Events() <-chan Event { return q.events }
+
+func (q *eventQueue) Errors() <-chan error { return q.errors }
+
+func (q *eventQueue) push(e Event) {
+	q.mu.Lock()
+	if !q.closed {
+		q.queue = append(q.queue, e)
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) pushError(err error) {
+	q.mu.Lock()
+	if !q.closed {
+		q.errs = append(q.errs, err)
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) loop() {
+	defer close(q.errors)
+	defer close(q.events)
+	for {
+		var (
+			events chan Event
+			errors chan error
+			event  Event
+			err    error
+		)
+		q.mu.Lock()
+		if len(q.queue) > 0 {
+			events, event = q.events, q.queue[0]
+		}
+		if len(q.errs) > 0 {
+			errors, err = q.errors, q.errs[0]
+		}
+		q.mu.Unlock()
+
+		select {
+		case events <- event:
+			q.mu.Lock()
+			if len(q.queue) > 0 {
+				q.queue = q.queue[1:]
+			}
+			q.mu.Unlock()
+		case errors <- err:
+			q.mu.Lock()
+			if len(q.errs) > 0 {
+				q.errs = q.errs[1:]
+			}
+			q.mu.Unlock()
+		case <-q.wake:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *eventQueue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.queue, q.errs = nil, nil
+	q.mu.Unlock()
+
+	close(q.done)
+	if q.stop != nil {
+		return q.stop()
+	}
+	return nil
+}
+
+// mapWatch returns a Watch passing the events of w through fn.
+func mapWatch(w Watch, fn func(Event) Event) Watch {
+	q := newEventQueue(w.Close)
+	go func() {
+		events, errs := w.Events(), w.Errors()
+		for events != nil || errs != nil {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				q.push(fn(e))
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				q.pushError(err)
+			}
+		}
+	}()
+	return q
+}
+
+// PollingWatcher is a Watcher for any Fs. It lists the watched paths at a
+// fixed interval and reports the differences between two listings.
+// It cannot tell a rename from a removal followed by a creation, and misses
+// writes that change neither the size nor the modification time of a file.
+type PollingWatcher struct {
+	fs       Fs
+	interval time.Duration
+}
+
+func NewPollingWatcher(fs Fs, interval time.Duration) *PollingWatcher {
+	return &PollingWatcher{fs: fs, interval: interval}
+}
+
+func (p *PollingWatcher) Watch(name string, recursive bool) (Watch, error) {
+	name = filepath.Clean(name)
+	if _, err := p.fs.Stat(name); err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: underlyingError(err)}
+	}
+	state, err := p.scan(name, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(p.interval)
+	q := newEventQueue(func() error {
+		ticker.Stop()
+		return nil
+	})
+	go func() {
+		for {
+			select {
+			case <-q.done:
+				return
+			case <-ticker.C:
+			}
+			next, err := p.scan(name, recursive)
+			if err != nil {
+				q.pushError(err)
+				continue
+			}
+			for _, e := range pollDiff(state, next) {
+				q.push(e)
+			}
+			state = next
+		}
+	}()
+	return q, nil
+}
+
+// pollState is what a PollingWatcher remembers of a file.
+type pollState struct {
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// scan lists name, and its entries if it is a directory. A missing name
+// gives an empty listing, its removal is reported as any other.
+func (p *PollingWatcher) scan(name string, recursive bool) (map[string]pollState, error) {
+	state := make(map[string]pollState)
+	add := func(path string, fi os.FileInfo) {
+		state[path] = pollState{size: fi.Size(), mode: fi.Mode(), modTime: fi.ModTime()}
+	}
+
+	fi, err := p.fs.Stat(name)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	add(name, fi)
+	if !fi.IsDir() {
+		return state, nil
+	}
+
+	if recursive {
+		err = Walk(p.fs, name, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				// Entries may go away while we walk
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			add(path, fi)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return state, nil
+	}
+
+	infos, err := ReadDir(p.fs, name)
+	if os.IsNotExist(err) {
+		return make(map[string]pollState), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range infos {
+		add(filepath.Join(name, fi.Name()), fi)
+	}
+	return state, nil
+}
+
+// pollDiff returns the events that turn the listing old into cur, sorted by
+// name. Directories are not reported as written when their entries change.
+func pollDiff(old, cur map[string]pollState) []Event {
+	var events []Event
+	for name, st := range cur {
+		prev, ok := old[name]
+		if !ok {
+			events = append(events, Event{Name: name, Op: Create})
+			continue
+		}
+		var op Op
+		if !st.mode.IsDir() && (st.size != prev.size || !st.modTime.Equal(prev.modTime)) {
+			op |= Write
+		}
+		if st.mode != prev.mode {
+			op |= Chmod
+		}
+		if op != 0 {
+			events = append(events, Event{Name: name, Op: op})
+		}
+	}
+	for name := range old {
+		if _, ok := cur[name]; !ok {
+			events = append(events, Event{Name: name, Op: Remove})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+	return events
+}
+
+// underlyingError returns the error wrapped by err, if it is one of the os
+// error types.
+func underlyingError(err error) error {
+	switch err := err.(type) {
+	case *os.PathError:
+		return err.Err
+	case *os.LinkError:
+		return err.Err
+	case *os.SyscallError:
+		return err.Err
+	}
+	return err
+}
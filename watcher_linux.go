@@ -0,0 +1,203 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package afero
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var _ Watcher = OsFs{}
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_DELETE | syscall.IN_DELETE_SELF |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_MOVE_SELF
+
+// Watch reports the changes to name with inotify. A recursive watch adds a
+// watch for every directory in the tree, including the ones created later.
+func (OsFs) Watch(name string, recursive bool) (Watch, error) {
+	name = filepath.Clean(name)
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: underlyingError(err)}
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	w := &inotifyWatch{
+		fd:        fd,
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		name:      name,
+		recursive: recursive,
+		paths:     make(map[int32]string),
+	}
+	if recursive && fi.IsDir() {
+		err = w.addTree(name, false)
+	} else {
+		err = w.add(name)
+	}
+	if err != nil {
+		w.file.Close()
+		return nil, &os.PathError{Op: "watch", Path: name, Err: underlyingError(err)}
+	}
+	w.eventQueue = newEventQueue(w.file.Close)
+	go w.read()
+	return w, nil
+}
+
+// inotifyWatch is a Watch on an OsFs.
+type inotifyWatch struct {
+	*eventQueue
+	fd        int
+	file      *os.File
+	name      string
+	recursive bool
+
+	mu    sync.Mutex
+	paths map[int32]string
+}
+
+func (w *inotifyWatch) add(path string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+	}
+	w.mu.Lock()
+	w.paths[int32(wd)] = path
+	w.mu.Unlock()
+	return nil
+}
+
+// addTree watches the directories below root. With report set the entries
+// found are reported as created, they may have appeared before the watch.
+func (w *inotifyWatch) addTree(root string, report bool) error {
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if report && path != root {
+			w.push(Event{Name: path, Op: Create})
+		}
+		if fi.IsDir() {
+			return w.add(path)
+		}
+		return nil
+	})
+}
+
+// remove drops the watches on path and the directories below it.
+func (w *inotifyWatch) remove(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wd, p := range w.paths {
+		if p == path || strings.HasPrefix(p, path+FilePathSeparator) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.paths, wd)
+		}
+	}
+}
+
+func (w *inotifyWatch) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				w.pushError(err)
+			}
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			off += syscall.SizeofInotifyEvent
+			name := ""
+			if raw.Len > 0 {
+				name = strings.TrimRight(string(buf[off:off+int(raw.Len)]), "\x00")
+				off += int(raw.Len)
+			}
+			w.handle(raw.Wd, raw.Mask, name)
+		}
+	}
+}
+
+func (w *inotifyWatch) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.pushError(errors.New("inotify event queue overflow"))
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.paths[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+	}
+	w.mu.Unlock()
+	if !ok || mask&syscall.IN_IGNORED != 0 {
+		return
+	}
+	// Below the root, the parent directory reports what happens to an entry
+	if name == "" && dir != w.name {
+		return
+	}
+
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+	isDir := mask&syscall.IN_ISDIR != 0
+	if w.recursive && isDir && mask&syscall.IN_MOVED_FROM != 0 {
+		w.remove(path)
+	}
+
+	var op Op
+	if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		op |= Create
+	}
+	if mask&syscall.IN_MODIFY != 0 {
+		op |= Write
+	}
+	if mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF) != 0 {
+		op |= Remove
+	}
+	if mask&(syscall.IN_MOVED_FROM|syscall.IN_MOVE_SELF) != 0 {
+		op |= Rename
+	}
+	if mask&syscall.IN_ATTRIB != 0 {
+		op |= Chmod
+	}
+	if op == 0 {
+		return
+	}
+	w.push(Event{Name: path, Op: op})
+
+	if w.recursive && isDir && op&Create != 0 {
+		if err := w.addTree(path, true); err != nil {
+			w.pushError(err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// nextEvent waits for the next event of w.
+func nextEvent(t *testing.T, w Watch) Event {
+	t.Helper()
+	select {
+	case e := <-w.Events():
+		return e
+	case err := <-w.Errors():
+		t.Fatalf("watch error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return Event{}
+}
+
+// waitForEvent skips events until one for name including op shows up.
+func waitForEvent(t *testing.T, w Watch, name string, op Op) {
+	t.Helper()
+	for {
+		if e := nextEvent(t, w); e.Name == name && e.Op&op != 0 {
+			return
+		}
+	}
+}
+
+func noEvent(t *testing.T, w Watch) {
+	t.Helper()
+	select {
+	case e := <-w.Events():
+		t.Errorf("unexpected event %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemMapFsWatch(t *testing.T) {
+	fs := NewMemMapFs()
+	fs.MkdirAll("/dir/sub", 0755)
+
+	w, err := fs.(Watcher).Watch("/dir", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	f, err := fs.Create("/dir/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("hello")
+	f.Close()
+	fs.Chmod("/dir/a", 0600)
+	fs.Rename("/dir/a", "/dir/b")
+	fs.Remove("/dir/b")
+	// Not below /dir, or too deep for a non recursive watch
+	WriteFile(fs, "/other", []byte("x"), 0644)
+	WriteFile(fs, "/dir/sub/deep", []byte("x"), 0644)
+	fs.Mkdir("/dir/new", 0755)
+
+	want := []Event{
+		{"/dir/a", Create},
+		{"/dir/a", Write},
+		{"/dir/a", Chmod},
+		{"/dir/a", Rename},
+		{"/dir/b", Create},
+		{"/dir/b", Remove},
+		{"/dir/new", Create},
+	}
+	for _, e := range want {
+		if got := nextEvent(t, w); got != e {
+			t.Errorf("got event %v, want %v", got, e)
+		}
+	}
+	noEvent(t, w)
+}
+
+func TestMemMapFsWatchRecursive(t *testing.T) {
+	fs := NewMemMapFs()
+	fs.MkdirAll("/dir/sub", 0755)
+
+	w, err := fs.(Watcher).Watch("/dir", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	WriteFile(fs, "/dir/sub/deep", []byte("x"), 0644)
+	WriteFile(fs, "/dirfoo", []byte("x"), 0644)
+	fs.RemoveAll("/dir/sub")
+
+	want := []Event{
+		{"/dir/sub/deep", Create},
+		{"/dir/sub/deep", Write},
+	}
+	for _, e := range want {
+		if got := nextEvent(t, w); got != e {
+			t.Errorf("got event %v, want %v", got, e)
+		}
+	}
+	removed := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		e := nextEvent(t, w)
+		if e.Op != Remove {
+			t.Errorf("got event %v, want a removal", e)
+		}
+		removed[e.Name] = true
+	}
+	if !removed["/dir/sub"] || !removed["/dir/sub/deep"] {
+		t.Errorf("removed %v, want /dir/sub and /dir/sub/deep", removed)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	WriteFile(fs, "/dir/late", []byte("x"), 0644)
+	if _, ok := <-w.Events(); ok {
+		t.Error("event delivered after Close")
+	}
+}
+
+func TestWatchMissing(t *testing.T) {
+	for _, fs := range []Fs{NewMemMapFs(), NewReadOnlyFs(NewMemMapFs()), &OsFs{}} {
+		_, err := NewWatch(fs, "/does/not/exist", false)
+		if !os.IsNotExist(err) {
+			t.Errorf("%s: Watch of a missing path returned %v, want a not exist error", fs.Name(), err)
+		}
+	}
+}
+
+func TestPollingWatcher(t *testing.T) {
+	fs := NewMemMapFs()
+	fs.MkdirAll("/dir/sub", 0755)
+	WriteFile(fs, "/dir/a", []byte("a"), 0644)
+
+	w, err := NewPollingWatcher(fs, 10*time.Millisecond).Watch("/dir", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	WriteFile(fs, "/dir/sub/b", []byte("b"), 0644)
+	waitForEvent(t, w, "/dir/sub/b", Create)
+
+	WriteFile(fs, "/dir/a", []byte("longer"), 0644)
+	waitForEvent(t, w, "/dir/a", Write)
+
+	fs.Chmod("/dir/a", 0600)
+	waitForEvent(t, w, "/dir/a", Chmod)
+
+	fs.Remove("/dir/sub/b")
+	waitForEvent(t, w, "/dir/sub/b", Remove)
+}
+
+func TestBasePathFsWatch(t *testing.T) {
+	fs := NewMemMapFs()
+	fs.MkdirAll("/base/dir", 0755)
+	bp := NewBasePathFs(fs, "/base")
+
+	w, err := bp.(Watcher).Watch("/dir", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	bp.Create("/dir/a")
+	if got, want := nextEvent(t, w), (Event{"/dir/a", Create}); got != want {
+		t.Errorf("got event %v, want %v", got, want)
+	}
+}
+
+func TestOsFsWatch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("OsFs polls on this platform")
+	}
+	osFs := &OsFs{}
+	dir, err := TempDir(osFs, "", "afero-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(dir)
+	// The tempdir may be a symlink, inotify reports resolved names
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := osFs.Watch(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	sub := filepath.Join(dir, "sub")
+	if err := osFs.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, w, sub, Create)
+
+	name := filepath.Join(sub, "a")
+	if err := WriteFile(osFs, name, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, w, name, Create)
+	waitForEvent(t, w, name, Write)
+
+	if err := osFs.Rename(name, filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, w, name, Rename)
+	waitForEvent(t, w, filepath.Join(dir, "b"), Create)
+
+	if err := osFs.Remove(filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, w, filepath.Join(dir, "b"), Remove)
+}
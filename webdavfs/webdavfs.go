@@ -0,0 +1,235 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webdavfs serves any afero.Fs over WebDAV, as a
+// golang.org/x/net/webdav FileSystem.
+package webdavfs
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/afero"
+	"golang.org/x/net/webdav"
+)
+
+var _ webdav.FileSystem = (*FileSystem)(nil)
+var _ webdav.DeadPropsHolder = (*File)(nil)
+
+// FileSystem is a webdav.FileSystem serving the files of an afero.Fs, for
+// reading and writing. The names of the requests are absolute in the Fs: a
+// BasePathFs serves a directory.
+//
+// As WebDAV requires, directories and files are only created in existing
+// directories, and the root cannot be renamed or removed. The dead
+// properties set by the clients are kept in memory, following the files
+// renamed or removed through the FileSystem.
+type FileSystem struct {
+	fs afero.Fs
+
+	mu    sync.Mutex
+	props map[string]map[xml.Name]webdav.Property // by clean slashed name
+}
+
+// New returns a FileSystem serving the files of fs.
+func New(fs afero.Fs) *FileSystem {
+	return &FileSystem{fs: fs, props: make(map[string]map[xml.Name]webdav.Property)}
+}
+
+// NewHandler returns a handler serving the files of fs over WebDAV, with
+// locks held in memory:
+//
+//	http.Handle("/dav/", webdavfs.NewHandler(fs, "/dav"))
+func NewHandler(fs afero.Fs, prefix string) *webdav.Handler {
+	return &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: New(fs),
+		LockSystem: webdav.NewMemLS(),
+	}
+}
+
+// resolve returns the clean slashed name of name, and its name in the Fs.
+func resolve(name string) (string, string, error) {
+	if filepath.Separator != '/' && strings.IndexRune(name, filepath.Separator) >= 0 ||
+		strings.Contains(name, "\x00") {
+		return "", "", os.ErrNotExist
+	}
+	name = path.Clean("/" + name)
+	return name, filepath.FromSlash(name), nil
+}
+
+// checkParent returns an error if the parent directory of name does not
+// exist, the Fs possibly creating it.
+func (d *FileSystem) checkParent(op, name string) error {
+	fi, err := d.fs.Stat(filepath.Dir(name))
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+func (d *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	_, name, err := resolve(name)
+	if err != nil {
+		return err
+	}
+	if err := d.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	return d.fs.Mkdir(name, perm)
+}
+
+func (d *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	key, name, err := resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE != 0 {
+		if err := d.checkParent("open", name); err != nil {
+			return nil, err
+		}
+	}
+	f, err := d.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: f, fs: d, key: key}, nil
+}
+
+func (d *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	key, name, err := resolve(name)
+	if err != nil {
+		return err
+	}
+	if key == "/" {
+		return os.ErrInvalid
+	}
+	if err := d.fs.RemoveAll(name); err != nil {
+		return err
+	}
+	d.moveProps(key, "")
+	return nil
+}
+
+func (d *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldKey, oldName, err := resolve(oldName)
+	if err != nil {
+		return err
+	}
+	newKey, newName, err := resolve(newName)
+	if err != nil {
+		return err
+	}
+	if oldKey == "/" || newKey == "/" {
+		return os.ErrInvalid
+	}
+	if err := d.checkParent("rename", newName); err != nil {
+		return err
+	}
+	if err := d.fs.Rename(oldName, newName); err != nil {
+		return err
+	}
+	d.moveProps(newKey, "")
+	d.moveProps(oldKey, newKey)
+	return nil
+}
+
+func (d *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	_, name, err := resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.fs.Stat(name)
+}
+
+// moveProps moves the dead properties of key and the files under it to
+// newKey, or drops them if newKey is empty.
+func (d *FileSystem) moveProps(key, newKey string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, props := range d.props {
+		if k != key && !strings.HasPrefix(k, key+"/") && key != "/" {
+			continue
+		}
+		delete(d.props, k)
+		if newKey != "" {
+			d.props[newKey+strings.TrimPrefix(k, key)] = props
+		}
+	}
+}
+
+// File is an afero.File opened through a FileSystem, holding the dead
+// properties of the file.
+type File struct {
+	afero.File
+	fs  *FileSystem
+	key string
+}
+
+// ReadFrom uses the io.ReaderFrom of the afero.File, if it has one, for
+// the uploads to be copied as the Fs does best.
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := f.File.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{f.File}, r)
+}
+
+// DeadProps returns the dead properties of the file.
+func (f *File) DeadProps() (map[xml.Name]webdav.Property, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	props := make(map[xml.Name]webdav.Property, len(f.fs.props[f.key]))
+	for name, prop := range f.fs.props[f.key] {
+		props[name] = prop
+	}
+	return props, nil
+}
+
+// Patch sets and removes dead properties of the file, all of them
+// succeeding.
+func (f *File) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	props := f.fs.props[f.key]
+	if props == nil {
+		props = make(map[xml.Name]webdav.Property)
+		f.fs.props[f.key] = props
+	}
+	stat := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			stat.Props = append(stat.Props, webdav.Property{XMLName: prop.XMLName})
+			if patch.Remove {
+				delete(props, prop.XMLName)
+			} else {
+				props[prop.XMLName] = prop
+			}
+		}
+	}
+	if len(props) == 0 {
+		delete(f.fs.props, f.key)
+	}
+	return []webdav.Propstat{stat}, nil
+}
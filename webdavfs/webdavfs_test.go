@@ -0,0 +1,161 @@
+package webdavfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// do sends a request to the server at url, and returns the status and body
+// of the response.
+func do(t *testing.T, method, url, body string, header ...string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func expect(t *testing.T, method, url, body string, want int, header ...string) string {
+	t.Helper()
+	status, data := do(t, method, url, body, header...)
+	if status != want {
+		t.Errorf("%s %s = %d, want %d: %s", method, url, status, want, data)
+	}
+	return data
+}
+
+func TestFileSystem(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	server := httptest.NewServer(NewHandler(fs, "/dav"))
+	defer server.Close()
+	url := server.URL + "/dav"
+
+	expect(t, "MKCOL", url+"/dir", "", http.StatusCreated)
+	expect(t, "MKCOL", url+"/dir", "", http.StatusMethodNotAllowed)
+	expect(t, "MKCOL", url+"/missing/dir", "", http.StatusConflict)
+	expect(t, "PUT", url+"/dir/file.txt", "content", http.StatusCreated)
+	expect(t, "PUT", url+"/missing/file.txt", "content", http.StatusConflict)
+	if exists, _ := afero.DirExists(fs, "/missing"); exists {
+		t.Error("PUT in a missing directory created it")
+	}
+	if data, err := afero.ReadFile(fs, "/dir/file.txt"); err != nil || string(data) != "content" {
+		t.Errorf("/dir/file.txt contains %q, %v", data, err)
+	}
+	if data := expect(t, "GET", url+"/dir/file.txt", "", http.StatusOK); data != "content" {
+		t.Errorf("GET /dir/file.txt = %q", data)
+	}
+
+	expect(t, "COPY", url+"/dir", "", http.StatusCreated, "Destination", url+"/copy")
+	expect(t, "MOVE", url+"/dir/file.txt", "", http.StatusCreated, "Destination", url+"/moved.txt")
+	if _, err := fs.Stat("/dir/file.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat of the moved file returned %v", err)
+	}
+	for _, name := range []string{"/copy/file.txt", "/moved.txt"} {
+		if data, err := afero.ReadFile(fs, name); err != nil || string(data) != "content" {
+			t.Errorf("%s contains %q, %v", name, data, err)
+		}
+	}
+
+	expect(t, "DELETE", url+"/copy", "", http.StatusNoContent)
+	if _, err := fs.Stat("/copy"); !os.IsNotExist(err) {
+		t.Errorf("Stat of the deleted directory returned %v", err)
+	}
+	expect(t, "DELETE", url+"/", "", http.StatusMethodNotAllowed)
+
+	data := expect(t, "PROPFIND", url+"/", "", http.StatusMultiStatus, "Depth", "1")
+	for _, name := range []string{"/dav/dir/", "/dav/moved.txt"} {
+		if !strings.Contains(data, "<D:href>"+name+"</D:href>") {
+			t.Errorf("PROPFIND / does not list %s: %s", name, data)
+		}
+	}
+}
+
+func TestDeadProps(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/file.txt", []byte("content"), 0644)
+	server := httptest.NewServer(NewHandler(fs, ""))
+	defer server.Close()
+
+	const propfind = `<?xml version="1.0"?>
+<D:propfind xmlns:D="DAV:"><D:prop><Z:author xmlns:Z="urn:z"/></D:prop></D:propfind>`
+	expect(t, "PROPPATCH", server.URL+"/file.txt", `<?xml version="1.0"?>
+<D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><Z:author xmlns:Z="urn:z">spf13</Z:author></D:prop></D:set></D:propertyupdate>`,
+		http.StatusMultiStatus)
+	if data := expect(t, "PROPFIND", server.URL+"/file.txt", propfind, http.StatusMultiStatus); !strings.Contains(data, "spf13") {
+		t.Errorf("PROPFIND does not return the property set: %s", data)
+	}
+
+	// The properties follow the file
+	expect(t, "MOVE", server.URL+"/file.txt", "", http.StatusCreated, "Destination", server.URL+"/moved.txt")
+	if data := expect(t, "PROPFIND", server.URL+"/moved.txt", propfind, http.StatusMultiStatus); !strings.Contains(data, "spf13") {
+		t.Errorf("PROPFIND of the moved file does not return the property set: %s", data)
+	}
+	expect(t, "DELETE", server.URL+"/moved.txt", "", http.StatusNoContent)
+	expect(t, "PUT", server.URL+"/moved.txt", "content", http.StatusCreated)
+	if data := expect(t, "PROPFIND", server.URL+"/moved.txt", propfind, http.StatusMultiStatus); strings.Contains(data, "spf13") {
+		t.Errorf("PROPFIND of a new file returns the property of a removed one: %s", data)
+	}
+}
+
+func TestLocks(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	server := httptest.NewServer(NewHandler(fs, ""))
+	defer server.Close()
+
+	req, err := http.NewRequest("LOCK", server.URL+"/file.txt", strings.NewReader(`<?xml version="1.0"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	token := resp.Header.Get("Lock-Token")
+	if resp.StatusCode != http.StatusCreated || token == "" {
+		t.Fatalf("LOCK = %d, token %q", resp.StatusCode, token)
+	}
+
+	expect(t, "PUT", server.URL+"/file.txt", "content", http.StatusLocked)
+	expect(t, "PUT", server.URL+"/file.txt", "content", http.StatusCreated, "If", "("+token+")")
+	expect(t, "UNLOCK", server.URL+"/file.txt", "", http.StatusNoContent, "Lock-Token", token)
+	expect(t, "PUT", server.URL+"/file.txt", "new content", http.StatusCreated)
+	if data, err := afero.ReadFile(fs, "/file.txt"); err != nil || string(data) != "new content" {
+		t.Errorf("/file.txt contains %q, %v", data, err)
+	}
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	base := afero.NewMemMapFs()
+	afero.WriteFile(base, "/file.txt", []byte("base"), 0644)
+	layer := afero.NewMemMapFs()
+	server := httptest.NewServer(NewHandler(afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(base), layer), ""))
+	defer server.Close()
+
+	expect(t, "PUT", server.URL+"/file.txt", "layer", http.StatusCreated)
+	if data := expect(t, "GET", server.URL+"/file.txt", "", http.StatusOK); data != "layer" {
+		t.Errorf("GET /file.txt = %q, want the layer", data)
+	}
+	if data, err := afero.ReadFile(base, "/file.txt"); err != nil || string(data) != "base" {
+		t.Errorf("the base file contains %q, %v", data, err)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.File = (*File)(nil)
+
+// File is an open entry of a zip archive.
+//
+// A stored entry is read in place. A compressed entry is decompressed as
+// it is read from the start; the first Read after a Seek elsewhere, or the
+// first ReadAt, decompresses all of it to a buffer the File reads from
+// afterwards, of the size set by Fs.SetMaxBuffer at most.
+type File struct {
+	name      string
+	e         *entry
+	closed    bool
+	dirAt     int
+	maxBuffer int64
+
+	mu       sync.Mutex
+	at       int64
+	content  io.ReaderAt   // the stored content, or the buffer
+	stream   io.ReadCloser // the content being decompressed
+	streamAt int64
+}
+
+func (fs *Fs) newFile(name string, e *entry) (*File, error) {
+	f := &File{name: name, e: e, maxBuffer: fs.maxBuffer}
+	switch {
+	case e.zf == nil || e.fi.IsDir():
+		f.content = bytes.NewReader(nil)
+	case e.zf.Method == zip.Store:
+		off, err := e.zf.DataOffset()
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		f.content = io.NewSectionReader(fs.r, off, int64(e.zf.UncompressedSize64))
+	}
+	return f, nil
+}
+
+func (f *File) Name() string { return f.name }
+
+func (f *File) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, afero.ErrFileClosed
+	}
+	return fileInfo(f.name, f.e), nil
+}
+
+func (f *File) Close() error {
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+	f.closed = true
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stream != nil {
+		return f.stream.Close()
+	}
+	return nil
+}
+
+// size returns the size of the content of f.
+func (f *File) size() int64 {
+	if f.e.zf == nil || f.e.fi.IsDir() {
+		return 0
+	}
+	return int64(f.e.zf.UncompressedSize64)
+}
+
+// buffer decompresses the content of f to memory, if it is not read in
+// place already, failing with afero.ErrTooLarge above f.maxBuffer bytes.
+// It is called with f.mu held.
+func (f *File) buffer() error {
+	if f.content != nil {
+		return nil
+	}
+	if f.maxBuffer > 0 && f.e.zf.UncompressedSize64 > uint64(f.maxBuffer) {
+		return afero.ErrTooLarge
+	}
+	rc, err := f.e.zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if f.maxBuffer > 0 {
+		r = io.LimitReader(rc, f.maxBuffer+1)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if f.maxBuffer > 0 && int64(len(data)) > f.maxBuffer {
+		return afero.ErrTooLarge
+	}
+	f.content = bytes.NewReader(data)
+	if f.stream != nil {
+		f.stream.Close()
+		f.stream = nil
+	}
+	return nil
+}
+
+func (f *File) Read(b []byte) (int, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+	if f.e.fi.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.content == nil && f.stream == nil && f.at == 0 {
+		rc, err := f.e.zf.Open()
+		if err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.stream, f.streamAt = rc, 0
+	}
+	if f.content == nil && f.at == f.streamAt {
+		n, err := f.stream.Read(b)
+		f.streamAt += int64(n)
+		f.at = f.streamAt
+		return n, err
+	}
+	if err := f.buffer(); err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	n, err := f.content.ReadAt(b, f.at)
+	f.at += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+	if f.e.fi.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.mu.Lock()
+	err := f.buffer()
+	content := f.content
+	f.mu.Unlock()
+	if err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return content.ReadAt(b, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.at
+	case io.SeekEnd:
+		offset += f.size()
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.at = offset
+	return offset, nil
+}
+
+// Readdir returns the entries of the directory in the order of their
+// names, count at most if count is positive, as os.File.Readdir does.
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, afero.ErrFileClosed
+	}
+	if !f.e.fi.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	children := f.e.children[f.dirAt:]
+	if count > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		if len(children) > count {
+			children = children[:count]
+		}
+	}
+	f.dirAt += len(children)
+	infos := make([]os.FileInfo, len(children))
+	for i, child := range children {
+		infos[i] = child.fi
+	}
+	return infos, nil
+}
+
+func (f *File) Readdirnames(n int) ([]string, error) {
+	infos, err := f.Readdir(n)
+	names := make([]string, len(infos))
+	for i, fi := range infos {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
+
+func (f *File) Sync() error {
+	return nil
+}
+
+func (f *File) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *File) Write(b []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
@@ -0,0 +1,324 @@
+// Copyright © 2018 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zipfs provides a read-only afero.Fs over the entries of a zip
+// archive.
+package zipfs
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.Lstater = (*Fs)(nil)
+var _ afero.LinkReader = (*Fs)(nil)
+var _ afero.Chowner = (*Fs)(nil)
+
+// maxSymlinks is the number of symbolic links followed while resolving a
+// single path before giving up with ELOOP.
+const maxSymlinks = 40
+
+// maxSymlinkTarget is the size above which the content of an entry cannot be
+// the target of a symbolic link.
+const maxSymlinkTarget = 4096
+
+// DefaultMaxBuffer is the size above which a Fs does not buffer the
+// content of a compressed entry, unless changed by SetMaxBuffer.
+const DefaultMaxBuffer = 64 << 20
+
+// Fs is a read-only afero.Fs over the entries of a zip archive, indexed
+// from its central directory when the Fs is created. The directories only
+// implied by the paths of the entries are added. Modes and times are those
+// of the zip headers.
+//
+// The content of the files stays in the archive: the stored entries are
+// read in place, at random, and the compressed ones are decompressed as
+// they are read, buffered in memory by the Files seeking in them.
+//
+// The methods modifying the filesystem fail with syscall.EPERM, as those of
+// afero.ReadOnlyFs do.
+type Fs struct {
+	r         io.ReaderAt
+	entries   map[string]*entry
+	maxBuffer int64
+}
+
+// entry is a file of the archive, by the clean slash separated absolute
+// path it has in the Fs.
+type entry struct {
+	name     string
+	fi       os.FileInfo
+	zf       *zip.File
+	target   string
+	children []*entry
+}
+
+// New returns a Fs with the entries of the zip archive in the size first
+// bytes of r. The content of the files is read from r when they are, so r
+// must stay available as long as the Fs is used.
+func New(r io.ReaderAt, size int64) (afero.Fs, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	fs := &Fs{r: r, maxBuffer: DefaultMaxBuffer, entries: map[string]*entry{
+		"/": {name: "/", fi: dirInfo("/", time.Time{})},
+	}}
+	for _, zf := range zr.File {
+		name := clean(zf.Name)
+		if name == "/" {
+			continue
+		}
+		e := &entry{name: name, fi: zf.FileInfo(), zf: zf}
+		if e.fi.Mode()&os.ModeSymlink != 0 {
+			if e.target, err = readTarget(zf); err != nil {
+				return nil, err
+			}
+		}
+		fs.addParents(name, zf.Modified)
+		fs.entries[name] = e
+	}
+	fs.index()
+	return fs, nil
+}
+
+// SetMaxBuffer bounds the content of a compressed entry a File buffers in
+// memory to size bytes, 0 meaning no limit. Reading a larger entry other
+// than from its start to its end fails with afero.ErrTooLarge, whatever
+// size its header claims. SetMaxBuffer must be called before fs is used.
+func (fs *Fs) SetMaxBuffer(size int64) {
+	fs.maxBuffer = size
+}
+
+// readTarget returns the target of the symbolic link zf, its content.
+func readTarget(zf *zip.File) (string, error) {
+	tooLarge := &os.PathError{Op: "readlink", Path: zf.Name, Err: afero.ErrTooLarge}
+	if zf.UncompressedSize64 > maxSymlinkTarget {
+		return "", tooLarge
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	// the size of the header is not to be trusted
+	target, err := ioutil.ReadAll(io.LimitReader(rc, maxSymlinkTarget+1))
+	if err != nil {
+		return "", err
+	}
+	if len(target) > maxSymlinkTarget {
+		return "", tooLarge
+	}
+	return string(target), nil
+}
+
+// dirInfo describes a directory only implied by the paths of the entries.
+func dirInfo(name string, mtime time.Time) os.FileInfo {
+	fh := &zip.FileHeader{Name: name + "/", Modified: mtime}
+	fh.SetMode(os.ModeDir | 0755)
+	return fh.FileInfo()
+}
+
+// addParents adds the directories above name missing from the index.
+func (fs *Fs) addParents(name string, mtime time.Time) {
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if e, ok := fs.entries[dir]; ok && e.fi.IsDir() {
+			continue
+		}
+		fs.entries[dir] = &entry{name: dir, fi: dirInfo(dir, mtime)}
+	}
+}
+
+// index fills the lists of entries of the directories, sorted by name.
+func (fs *Fs) index() {
+	for name, e := range fs.entries {
+		if name == "/" {
+			continue
+		}
+		if parent, ok := fs.entries[path.Dir(name)]; ok {
+			parent.children = append(parent.children, e)
+		}
+	}
+	for _, e := range fs.entries {
+		children := e.children
+		sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	}
+}
+
+// clean returns the path of name in the index.
+func clean(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// resolve follows the symbolic links in name and returns the path of the
+// entry it refers to, the last element only if followLast is set.
+// Elements that do not exist are left as they are, the caller will notice.
+func (fs *Fs) resolve(name string, followLast bool) (string, error) {
+	name = clean(name)
+	hops := 0
+resolve:
+	for {
+		parts := strings.Split(name, "/")
+		cur := "/"
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			next := path.Join(cur, part)
+			e, ok := fs.entries[next]
+			if !ok {
+				return name, nil
+			}
+			if e.fi.Mode()&os.ModeSymlink != 0 && (followLast || i < len(parts)-1) {
+				if hops++; hops > maxSymlinks {
+					return name, syscall.ELOOP
+				}
+				target := e.target
+				if !path.IsAbs(target) {
+					target = path.Join(cur, target)
+				}
+				name = clean(path.Join(append([]string{target}, parts[i+1:]...)...))
+				continue resolve
+			}
+			cur = next
+		}
+		return name, nil
+	}
+}
+
+// lookup returns the entry at name.
+func (fs *Fs) lookup(op, name string, followLast bool) (*entry, error) {
+	resolved, err := fs.resolve(name, followLast)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	e, ok := fs.entries[resolved]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (fs *Fs) Name() string { return "zipfs" }
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	e, err := fs.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.newFile(name, e)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, syscall.EPERM
+	}
+	return fs.Open(name)
+}
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	e, err := fs.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo(name, e), nil
+}
+
+func (fs *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	e, err := fs.lookup("lstat", name, false)
+	if err != nil {
+		return nil, true, err
+	}
+	return e.fi, true, nil
+}
+
+func (fs *Fs) ReadlinkIfPossible(name string) (string, error) {
+	e, err := fs.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e.fi.Mode()&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return e.target, nil
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return nil, syscall.EPERM
+}
+
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Remove(name string) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) RemoveAll(path string) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Rename(oldname, newname string) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Chown(name string, uid, gid int) error {
+	return syscall.EPERM
+}
+
+func (fs *Fs) Lchown(name string, uid, gid int) error {
+	return syscall.EPERM
+}
+
+// fileInfo describes e, found at name, under the base name of name: as
+// with os.Stat, a file reached through a symbolic link is named after it.
+func fileInfo(name string, e *entry) os.FileInfo {
+	if base := path.Base(clean(name)); base != e.fi.Name() {
+		return &namedFileInfo{FileInfo: e.fi, name: base}
+	}
+	return e.fi
+}
+
+type namedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *namedFileInfo) Name() string { return fi.name }
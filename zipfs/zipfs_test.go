@@ -0,0 +1,290 @@
+package zipfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+)
+
+// archive writes the content of fs to a zip archive, its files compressed
+// with method.
+func archive(t *testing.T, fs afero.Fs, method uint16) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	err := afero.Walk(fs, "/", func(name string, fi os.FileInfo, err error) error {
+		if err != nil || name == "/" {
+			return err
+		}
+		fh, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		fh.Name = strings.TrimPrefix(filepath.ToSlash(name), "/")
+		fh.Method = method
+		if fi.IsDir() {
+			fh.Name += "/"
+		}
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			data, err := afero.ReadFile(fs, name)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
+		}
+		return nil
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newMemMapFs(t *testing.T) afero.Fs {
+	return afero.NewMemMapFs()
+}
+
+func readOnly(t *testing.T, method uint16) func(afero.Fs) afero.Fs {
+	return func(fs afero.Fs) afero.Fs {
+		data := archive(t, fs, method)
+		zfs, err := New(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return zfs
+	}
+}
+
+func TestSuite(t *testing.T) {
+	aferotest.Suite{NewFs: newMemMapFs, ReadOnly: readOnly(t, zip.Deflate)}.Run(t)
+}
+
+func TestSuiteStored(t *testing.T) {
+	aferotest.Suite{NewFs: newMemMapFs, ReadOnly: readOnly(t, zip.Store)}.Run(t)
+}
+
+// entries writes a zip archive of the given headers, the regular files
+// holding their name as content and symbolic links their target.
+func entries(t *testing.T, links map[string]string, fhs ...*zip.FileHeader) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, fh := range fhs {
+		content := fh.Name
+		if target, ok := links[fh.Name]; ok {
+			fh.SetMode(os.ModeSymlink | 0777)
+			content = target
+		} else if fh.Mode() == 0 {
+			fh.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fh.Mode().IsDir() {
+			w.Write([]byte(content))
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkContent(t *testing.T, fs afero.Fs, name, want string) {
+	t.Helper()
+	data, err := afero.ReadFile(fs, name)
+	if err != nil || string(data) != want {
+		t.Errorf("%s contains %q, %v, want %q", name, data, err, want)
+	}
+}
+
+func TestFs(t *testing.T) {
+	mtime := time.Date(2018, 1, 2, 3, 4, 6, 0, time.UTC)
+	dir := &zip.FileHeader{Name: "usr/share/", Modified: mtime}
+	dir.SetMode(os.ModeDir | 0700)
+	exe := &zip.FileHeader{Name: "usr/bin/tool", Method: zip.Deflate, Modified: mtime}
+	exe.SetMode(0755)
+	data := entries(t, map[string]string{"usr/lib": "/usr/share", "loop": "loop"},
+		&zip.FileHeader{Name: "etc/conf", Method: zip.Deflate, Modified: mtime},
+		&zip.FileHeader{Name: "usr/share/doc/README"},
+		dir,
+		exe,
+		&zip.FileHeader{Name: "usr/lib"},
+		&zip.FileHeader{Name: "loop"},
+	)
+	fs, err := New(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkContent(t, fs, "/etc/conf", "etc/conf")
+	checkContent(t, fs, "/usr/lib/doc/README", "usr/share/doc/README")
+
+	// Implicit directories are synthesized, explicit ones kept
+	for name, mode := range map[string]os.FileMode{
+		"/":             os.ModeDir | 0755,
+		"/etc":          os.ModeDir | 0755,
+		"/usr":          os.ModeDir | 0755,
+		"/usr/share":    os.ModeDir | 0700,
+		"/usr/bin/tool": 0755,
+	} {
+		if fi, err := fs.Stat(name); err != nil || fi.Mode() != mode {
+			t.Errorf("Stat(%q) = %v, %v, want a mode of %v", name, fi, err, mode)
+		}
+	}
+	fi, err := fs.Stat("/etc/conf")
+	if err != nil || fi.Name() != "conf" || fi.Size() != int64(len("etc/conf")) || !fi.ModTime().Equal(mtime) {
+		t.Errorf("Stat(/etc/conf) = %v, %v", fi, err)
+	}
+	if fi, err := fs.Stat("/etc"); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("Stat of an implicit directory = %v, %v, want the time of its entry", fi, err)
+	}
+	if fi, _, err := fs.(afero.Lstater).LstatIfPossible("/usr/lib"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat of a symbolic link = %v, %v", fi, err)
+	}
+	if target, err := fs.(afero.LinkReader).ReadlinkIfPossible("/usr/lib"); err != nil || target != "/usr/share" {
+		t.Errorf("Readlink = %q, %v", target, err)
+	}
+	if _, err := fs.Stat("/loop"); err == nil || !strings.Contains(err.Error(), "too many levels") {
+		t.Errorf("Stat of a symbolic link loop = %v", err)
+	}
+
+	var walked []string
+	afero.Walk(fs, "/", func(name string, fi os.FileInfo, err error) error {
+		walked = append(walked, name)
+		return err
+	})
+	want := []string{"/", "/etc", "/etc/conf", "/loop", "/usr", "/usr/bin", "/usr/bin/tool",
+		"/usr/lib", "/usr/share", "/usr/share/doc", "/usr/share/doc/README"}
+	if strings.Join(walked, " ") != strings.Join(want, " ") {
+		t.Errorf("walked %v, want %v", walked, want)
+	}
+
+	if err := fs.Remove("/etc/conf"); err != syscall.EPERM {
+		t.Errorf("Remove returned %v, want EPERM", err)
+	}
+	if _, err := fs.OpenFile("/etc/conf", os.O_RDWR, 0); err != syscall.EPERM {
+		t.Errorf("OpenFile for writing returned %v, want EPERM", err)
+	}
+}
+
+func TestLargeSymlink(t *testing.T) {
+	target := strings.Repeat("x/", maxSymlinkTarget)
+	data := entries(t, map[string]string{"link": target}, &zip.FileHeader{Name: "link"})
+	if _, err := New(bytes.NewReader(data), int64(len(data))); err == nil || !strings.Contains(err.Error(), afero.ErrTooLarge.Error()) {
+		t.Errorf("New with a symbolic link of %d bytes returned %v, want ErrTooLarge", len(target), err)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, _ := zw.CreateHeader(&zip.FileHeader{Name: "f", Method: method})
+		io.WriteString(w, content)
+		zw.Close()
+		fs, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := fs.Open("/f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 5)
+		if n, err := io.ReadFull(f, b); err != nil || string(b[:n]) != "01234" {
+			t.Errorf("method %d: Read = %q, %v", method, b[:n], err)
+		}
+		if n, err := f.ReadAt(b, 9997); err != io.EOF || string(b[:n]) != "789" {
+			t.Errorf("method %d: ReadAt at the end = %q, %v", method, b[:n], err)
+		}
+		// Reading goes on where it stopped
+		if n, err := io.ReadFull(f, b); err != nil || string(b[:n]) != "56789" {
+			t.Errorf("method %d: Read after ReadAt = %q, %v", method, b[:n], err)
+		}
+		if pos, err := f.Seek(-12, io.SeekEnd); err != nil || pos != 9988 {
+			t.Errorf("method %d: Seek = %d, %v", method, pos, err)
+		}
+		rest, err := ioutil.ReadAll(f)
+		if err != nil || string(rest) != "890123456789" {
+			t.Errorf("method %d: Read after Seek = %q, %v", method, rest, err)
+		}
+		if _, err := f.Seek(-1, io.SeekStart); err == nil {
+			t.Errorf("method %d: Seek before the start succeeded", method)
+		}
+		f.Close()
+	}
+}
+
+func TestMaxBuffer(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "f", Method: zip.Deflate})
+	io.WriteString(w, content)
+	zw.Close()
+	fs, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.(*Fs).SetMaxBuffer(1000)
+
+	f, err := fs.Open("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 5)
+	if _, err := f.ReadAt(b, 100); err == nil || !strings.Contains(err.Error(), afero.ErrTooLarge.Error()) {
+		t.Errorf("ReadAt in an entry larger than the buffer returned %v, want ErrTooLarge", err)
+	}
+	// Reading from the start to the end needs no buffer
+	checkContent(t, fs, "/f", content)
+}
+
+func TestCopyOnWriteBase(t *testing.T) {
+	data := entries(t, nil, &zip.FileHeader{Name: "a/b", Method: zip.Deflate})
+	zfs, err := New(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := afero.NewMemMapFs()
+	fs := afero.NewCopyOnWriteFs(zfs, layer)
+
+	if err := afero.WriteFile(fs, "/a/c", []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFile("/a/b", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("+")
+	f.Close()
+
+	checkContent(t, fs, "/a/b", "a/b+")
+	checkContent(t, fs, "/a/c", "c")
+	checkContent(t, zfs, "/a/b", "a/b")
+	names, err := afero.ReadDir(fs, "/a")
+	if err != nil || len(names) != 2 {
+		t.Errorf("ReadDir of the merged directory = %v, %v", names, err)
+	}
+}